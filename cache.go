@@ -0,0 +1,150 @@
+package hclutil
+
+import (
+	"crypto/sha256"
+	"io/fs"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// ParseCache は 解析済みのファイルをパスと内容のハッシュで記憶し、変更されたファイルだけを再解析します。
+// 設定の再読み込みを繰り返す長時間動作するプロセスで使うことを想定しています。
+// Parse や ParseFS で見つからなかったファイルは、削除や名前の変更に追従するために記憶から破棄されます。
+// そのため、1つの ParseCache は1つの設定の読み込みに使います。
+// ParseCache は複数のgoroutineから同時に使うことができます。
+//
+// ParseCache remembers parsed files keyed by path and content hash, and re-parses only changed files.
+// It is intended for long-running processes that reload the configuration repeatedly.
+// Files not found by Parse or ParseFS are discarded, following deleted and renamed files.
+// Therefore use one ParseCache for loading one configuration.
+// ParseCache is safe for concurrent use by multiple goroutines.
+type ParseCache struct {
+	optFns []func(*parseOptions)
+
+	mu      sync.Mutex
+	entries map[string]*parseCacheEntry
+	hook    func(path string, hit bool)
+	hits    int
+	misses  int
+}
+
+type parseCacheEntry struct {
+	sum    [sha256.Size]byte
+	format FileFormat
+	file   *hcl.File
+	diags  hcl.Diagnostics
+}
+
+// NewParseCache は 新しい ParseCache を返します。optFns は Parse や ParseFS と同じオプションです。
+// NewParseCache returns a new ParseCache. optFns are the same options as Parse and ParseFS.
+func NewParseCache(optFns ...func(*parseOptions)) *ParseCache {
+	return &ParseCache{
+		optFns:  optFns,
+		entries: make(map[string]*parseCacheEntry),
+	}
+}
+
+// SetHook は ファイルを解析するたびに呼ばれる関数を設定します。キャッシュを使った場合は hit が true になります。
+// 関数は複数のgoroutineから同時に呼ばれることがあります。
+//
+// SetHook sets a function called every time a file is parsed. hit is true when the cached result was used.
+// The function may be called concurrently from multiple goroutines.
+func (c *ParseCache) SetHook(hook func(path string, hit bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hook = hook
+}
+
+// Hits は キャッシュを使った回数を返します。
+// Hits returns the number of times the cached result was used.
+func (c *ParseCache) Hits() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// Misses は ファイルを解析し直した回数を返します。
+// Misses returns the number of times a file was parsed again.
+func (c *ParseCache) Misses() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.misses
+}
+
+// Reset は 記憶しているファイルと回数を破棄します。
+// Reset discards the remembered files and counts.
+func (c *ParseCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*parseCacheEntry)
+	c.hits = 0
+	c.misses = 0
+}
+
+// Parse は Parse と同じですが、内容が変わっていないファイルは前回の解析結果を使います。
+// Parse is the same as Parse, but uses the previous result for files whose content has not changed.
+func (c *ParseCache) Parse(p string) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	opts := c.parseOptions()
+	defer c.prune(opts.cacheSeen)
+	return parsePath(p, opts)
+}
+
+// ParseFS は ParseFS と同じですが、内容が変わっていないファイルは前回の解析結果を使います。
+// ParseFS is the same as ParseFS, but uses the previous result for files whose content has not changed.
+func (c *ParseCache) ParseFS(fsys fs.FS) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	opts := c.parseOptions()
+	defer c.prune(opts.cacheSeen)
+	return parseFS("", hclparse.NewParser(), fsys, opts)
+}
+
+func (c *ParseCache) parseOptions() *parseOptions {
+	opts := newParseOptions(c.optFns...)
+	opts.cache = c
+	opts.cacheSeen = make(map[string]bool)
+	return opts
+}
+
+// prune は 1回の解析で見つからなかったファイルを破棄します。
+func (c *ParseCache) prune(seen map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range c.entries {
+		if !seen[name] {
+			delete(c.entries, name)
+		}
+	}
+}
+
+func (c *ParseCache) parseFile(name string, src []byte, format FileFormat, seen map[string]bool) (*hcl.File, hcl.Diagnostics) {
+	sum := sha256.Sum256(src)
+	c.mu.Lock()
+	seen[name] = true
+	entry, ok := c.entries[name]
+	hit := ok && entry.sum == sum && entry.format == format
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	hook := c.hook
+	c.mu.Unlock()
+	if hook != nil {
+		hook(name, hit)
+	}
+	if hit {
+		return entry.file, entry.diags
+	}
+
+	file, diags := parseFile(name, src, format)
+	c.mu.Lock()
+	c.entries[name] = &parseCacheEntry{
+		sum:    sum,
+		format: format,
+		file:   file,
+		diags:  diags,
+	}
+	c.mu.Unlock()
+	return file, diags
+}
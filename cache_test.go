@@ -0,0 +1,78 @@
+package hclutil_test
+
+import (
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCache(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"a.hcl":      {Data: []byte(`a = 1`)},
+		"b.hcl.json": {Data: []byte(`{"b": 2}`)},
+	}
+	cache := hclutil.NewParseCache()
+	var mu sync.Mutex
+	events := map[string][]bool{}
+	cache.SetHook(func(path string, hit bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[path] = append(events[path], hit)
+	})
+
+	body, writer, diags := cache.ParseFS(testFs)
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []string{"a.hcl", "b.hcl.json"}, writer.Files())
+	attrs, diags := body.JustAttributes()
+	diagsReport(t, diags)
+	require.Len(t, attrs, 2)
+	require.Equal(t, 0, cache.Hits())
+	require.Equal(t, 2, cache.Misses())
+
+	testFs["a.hcl"] = &fstest.MapFile{Data: []byte("a = 1\nc = 3\n")}
+	body, writer, diags = cache.ParseFS(testFs)
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []string{"a.hcl", "b.hcl.json"}, writer.Files())
+	attrs, diags = body.JustAttributes()
+	diagsReport(t, diags)
+	require.Len(t, attrs, 3)
+	require.Equal(t, 1, cache.Hits())
+	require.Equal(t, 3, cache.Misses())
+	require.Equal(t, map[string][]bool{
+		"a.hcl":      {false, false},
+		"b.hcl.json": {false, true},
+	}, events)
+
+	delete(testFs, "b.hcl.json")
+	_, writer, diags = cache.ParseFS(testFs)
+	diagsReport(t, diags)
+	require.Equal(t, []string{"a.hcl"}, writer.Files())
+	testFs["b.hcl.json"] = &fstest.MapFile{Data: []byte(`{"b": 2}`)}
+	_, _, diags = cache.ParseFS(testFs)
+	diagsReport(t, diags)
+	require.Equal(t, map[string][]bool{
+		"a.hcl":      {false, false, true, true},
+		"b.hcl.json": {false, true, false},
+	}, events, "removed files must be parsed again when they come back")
+
+	cache.Reset()
+	require.Equal(t, 0, cache.Hits())
+	require.Equal(t, 0, cache.Misses())
+}
+
+func TestParseCache__Parse(t *testing.T) {
+	t.Parallel()
+	cache := hclutil.NewParseCache()
+	for i := 0; i < 3; i++ {
+		body, writer, diags := cache.Parse("testdata/hcl_file.hcl")
+		diagsReport(t, diags)
+		require.NotNil(t, body)
+		require.Equal(t, []string{"testdata/hcl_file.hcl"}, writer.Files())
+	}
+	require.Equal(t, 2, cache.Hits())
+	require.Equal(t, 1, cache.Misses())
+}
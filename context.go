@@ -1,10 +1,15 @@
 package hclutil
 
 import (
+	"fmt"
+	"math/big"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
 )
 
 // NewEvalContext は よく使う基本的な関数を登録したEvalContextを作成します。
@@ -32,7 +37,13 @@ func WithVariables(ctx *hcl.EvalContext, variables map[string]cty.Value) *hcl.Ev
 
 // WithValue returns a new EvalContext with path's value set.
 // ctx = WithValue(ctx, "a.b.c", cty.StringVal("hoge"))
+//
+// path は SetValue と同じく HCLのトラバーサルとして解析されるため、"servers[0].name" や "tags[\"app.kubernetes.io/name\"]" も指定できます。
+// トラバーサルとして解析できない場合は、従来どおり "." で区切ったオブジェクトの階層として扱います。
 func WithValue(ctx *hcl.EvalContext, path string, value cty.Value) *hcl.EvalContext {
+	if cctx, diags := SetValue(ctx, path, value); !diags.HasErrors() {
+		return cctx
+	}
 	paths := strings.Split(path, ".")
 	variables := make(map[string]cty.Value, 1)
 	variables[paths[len(paths)-1]] = value
@@ -41,3 +52,335 @@ func WithValue(ctx *hcl.EvalContext, path string, value cty.Value) *hcl.EvalCont
 	}
 	return WithVariables(ctx, variables)
 }
+
+const valuePathFilename = "<value path>"
+
+// parseValuePath は "a.b[0][\"c\"]" のようなパスをHCLの絶対トラバーサルとして解析します。
+func parseValuePath(path string) (hcl.Traversal, hcl.Diagnostics) {
+	return hclsyntax.ParseTraversalAbs([]byte(path), valuePathFilename, hcl.Pos{Line: 1, Column: 1})
+}
+
+// GetValue は ctx から path の値を取得します。path は "a.b.c" や "servers[0].name" のようなHCLのトラバーサルです。
+// GetValue returns the value at path in ctx. path is an HCL traversal such as "a.b.c" or "servers[0].name".
+func GetValue(ctx *hcl.EvalContext, path string) (cty.Value, hcl.Diagnostics) {
+	traversal, diags := parseValuePath(path)
+	if diags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+	return traversal.TraverseAbs(ctx)
+}
+
+// SetValue は path に value を設定した新しいEvalContextを返します。
+// 途中のオブジェクトやマップは必要に応じて作られます。リストやタプルの要素は既存の添字だけを設定できます。
+//
+// SetValue returns a new EvalContext with value set at path.
+// Intermediate objects and maps are created as needed. Only existing indices of lists and tuples can be set.
+//
+//	ctx, diags = SetValue(ctx, "servers[0].name", cty.StringVal("web"))
+func SetValue(ctx *hcl.EvalContext, path string, value cty.Value) (*hcl.EvalContext, hcl.Diagnostics) {
+	traversal, diags := parseValuePath(path)
+	if diags.HasErrors() {
+		return ctx, diags
+	}
+	root := traversal.RootName()
+	current, ok := VisibleVariables(ctx)[root]
+	if !ok {
+		current = cty.NilVal
+	}
+	newRoot, diags := setValuePath(current, traversal, 1, value)
+	if diags.HasErrors() {
+		return ctx, diags
+	}
+	return WithVariables(ctx, map[string]cty.Value{root: newRoot}), nil
+}
+
+// WithoutValue は path の値を取り除いた新しいEvalContextを返します。path が存在しない場合は何もしません。
+// 親のEvalContextの変数を隠すことはできないため、返されるEvalContextは親を持たず、変数と関数は平坦化されます。
+//
+// WithoutValue returns a new EvalContext with the value at path removed. It does nothing if path does not exist.
+// Since a child cannot hide variables of its parents, the returned EvalContext has no parent and its variables and functions are flattened.
+func WithoutValue(ctx *hcl.EvalContext, path string) (*hcl.EvalContext, hcl.Diagnostics) {
+	traversal, diags := parseValuePath(path)
+	if diags.HasErrors() {
+		return ctx, diags
+	}
+	variables := VisibleVariables(ctx)
+	root := traversal.RootName()
+	if current, ok := variables[root]; ok {
+		if len(traversal) == 1 {
+			delete(variables, root)
+		} else {
+			newRoot, diags := deleteValuePath(current, traversal, 1)
+			if diags.HasErrors() {
+				return ctx, diags
+			}
+			variables[root] = newRoot
+		}
+	}
+	return &hcl.EvalContext{
+		Variables: variables,
+		Functions: VisibleFunctions(ctx),
+	}, nil
+}
+
+// valuePathKey は トラバーサルの1段を、オブジェクトのキーか数値の添字に変換します。
+func valuePathKey(tr hcl.Traverser) (string, int, bool, hcl.Diagnostics) {
+	switch tr := tr.(type) {
+	case hcl.TraverseAttr:
+		return tr.Name, 0, false, nil
+	case hcl.TraverseIndex:
+		key, _ := tr.Key.Unmark()
+		if !key.IsKnown() || key.IsNull() {
+			break
+		}
+		switch key.Type() {
+		case cty.String:
+			return key.AsString(), 0, false, nil
+		case cty.Number:
+			bf := key.AsBigFloat()
+			if i, acc := bf.Int64(); acc == big.Exact {
+				return "", int(i), true, nil
+			}
+		}
+	}
+	return "", 0, false, hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid value path",
+		Detail:   "Only attribute names, string keys and integer indices are supported.",
+		Subject:  tr.SourceRange().Ptr(),
+	}}
+}
+
+func valuePathError(traversal hcl.Traversal, i int, detail string) hcl.Diagnostics {
+	return hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid value path",
+		Detail:   fmt.Sprintf("Cannot access %s: %s", TraversalToString(traversal[:i+1]), detail),
+		Subject:  traversal[i].SourceRange().Ptr(),
+	}}
+}
+
+func setValuePath(current cty.Value, traversal hcl.Traversal, i int, value cty.Value) (cty.Value, hcl.Diagnostics) {
+	if i >= len(traversal) {
+		return value, nil
+	}
+	name, index, isIndex, diags := valuePathKey(traversal[i])
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+	if current == cty.NilVal || current.IsNull() {
+		if isIndex {
+			return cty.NilVal, valuePathError(traversal, i, "the value does not exist")
+		}
+		elem, diags := setValuePath(cty.NilVal, traversal, i+1, value)
+		if diags.HasErrors() {
+			return cty.NilVal, diags
+		}
+		return cty.ObjectVal(map[string]cty.Value{name: elem}), nil
+	}
+	if !current.IsKnown() {
+		return cty.NilVal, valuePathError(traversal, i, "the value is unknown")
+	}
+	current, marks := current.Unmark()
+	ty := current.Type()
+	switch {
+	case !isIndex && (ty.IsObjectType() || ty.IsMapType()):
+		elems := current.AsValueMap()
+		if elems == nil {
+			elems = make(map[string]cty.Value, 1)
+		}
+		elem, ok := elems[name]
+		if !ok {
+			elem = cty.NilVal
+		}
+		elem, diags := setValuePath(elem, traversal, i+1, value)
+		if diags.HasErrors() {
+			return cty.NilVal, diags
+		}
+		elems[name] = elem
+		if ty.IsMapType() && sameElementTypes(mapElements(elems)) {
+			return cty.MapVal(elems).WithMarks(marks), nil
+		}
+		return cty.ObjectVal(elems).WithMarks(marks), nil
+	case isIndex && (ty.IsListType() || ty.IsTupleType()):
+		elems := current.AsValueSlice()
+		if index < 0 || index >= len(elems) {
+			return cty.NilVal, valuePathError(traversal, i, fmt.Sprintf("the index %d is out of range", index))
+		}
+		elem, diags := setValuePath(elems[index], traversal, i+1, value)
+		if diags.HasErrors() {
+			return cty.NilVal, diags
+		}
+		elems[index] = elem
+		if ty.IsListType() && sameElementTypes(elems) {
+			return cty.ListVal(elems).WithMarks(marks), nil
+		}
+		return cty.TupleVal(elems).WithMarks(marks), nil
+	}
+	return cty.NilVal, valuePathError(traversal, i, fmt.Sprintf("a %s value cannot be indexed this way", ty.FriendlyName()))
+}
+
+func deleteValuePath(current cty.Value, traversal hcl.Traversal, i int) (cty.Value, hcl.Diagnostics) {
+	name, index, isIndex, diags := valuePathKey(traversal[i])
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+	if current.IsNull() || !current.IsKnown() {
+		return current, nil
+	}
+	current, marks := current.Unmark()
+	ty := current.Type()
+	last := i == len(traversal)-1
+	switch {
+	case !isIndex && (ty.IsObjectType() || ty.IsMapType()):
+		elems := current.AsValueMap()
+		elem, ok := elems[name]
+		if !ok {
+			return current.WithMarks(marks), nil
+		}
+		if last {
+			delete(elems, name)
+		} else {
+			elem, diags := deleteValuePath(elem, traversal, i+1)
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			elems[name] = elem
+		}
+		if ty.IsMapType() {
+			if len(elems) == 0 {
+				return cty.MapValEmpty(ty.ElementType()).WithMarks(marks), nil
+			}
+			if sameElementTypes(mapElements(elems)) {
+				return cty.MapVal(elems).WithMarks(marks), nil
+			}
+		}
+		return cty.ObjectVal(elems).WithMarks(marks), nil
+	case isIndex && (ty.IsListType() || ty.IsTupleType()):
+		elems := current.AsValueSlice()
+		if index < 0 || index >= len(elems) {
+			return current.WithMarks(marks), nil
+		}
+		if last {
+			elems = append(elems[:index], elems[index+1:]...)
+		} else {
+			elem, diags := deleteValuePath(elems[index], traversal, i+1)
+			if diags.HasErrors() {
+				return cty.NilVal, diags
+			}
+			elems[index] = elem
+		}
+		if ty.IsListType() {
+			if len(elems) == 0 {
+				return cty.ListValEmpty(ty.ElementType()).WithMarks(marks), nil
+			}
+			if sameElementTypes(elems) {
+				return cty.ListVal(elems).WithMarks(marks), nil
+			}
+		}
+		return cty.TupleVal(elems).WithMarks(marks), nil
+	}
+	return current.WithMarks(marks), nil
+}
+
+// sameElementTypes は すべての要素が同じ型かどうかを返します。マップやリストを作り直せるかの判定に使います。
+func sameElementTypes(elems []cty.Value) bool {
+	for i := 1; i < len(elems); i++ {
+		if !elems[i].Type().Equals(elems[0].Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+func mapElements(elems map[string]cty.Value) []cty.Value {
+	values := make([]cty.Value, 0, len(elems))
+	for _, v := range elems {
+		values = append(values, v)
+	}
+	return values
+}
+
+// VisibleVariables は ctx とその親から参照できる変数を返します。
+// HCLの評価と同じく、同じ名前の変数は子のEvalContextのものが優先されます。
+//
+// VisibleVariables returns the variables visible from ctx and its parents.
+// As in HCL evaluation, a variable in a child EvalContext takes precedence over one with the same name in its parents.
+func VisibleVariables(ctx *hcl.EvalContext) map[string]cty.Value {
+	variables := make(map[string]cty.Value)
+	for current := ctx; current != nil; current = current.Parent() {
+		for name, value := range current.Variables {
+			if _, ok := variables[name]; !ok {
+				variables[name] = value
+			}
+		}
+	}
+	return variables
+}
+
+// VisibleFunctions は ctx とその親から呼び出せる関数を返します。同じ名前の関数は子のEvalContextのものが優先されます。
+// VisibleFunctions returns the functions callable from ctx and its parents. A function in a child EvalContext takes precedence.
+func VisibleFunctions(ctx *hcl.EvalContext) map[string]function.Function {
+	functions := make(map[string]function.Function)
+	for current := ctx; current != nil; current = current.Parent() {
+		for name, fn := range current.Functions {
+			if _, ok := functions[name]; !ok {
+				functions[name] = fn
+			}
+		}
+	}
+	return functions
+}
+
+// DumpEvalContext は ctx から参照できる変数と関数を名前順に文字列にします。
+// 変数の値は DumpCTYValue で出力されるため、SensitiveMark が付与された値は伏せ字になります。
+// これは、デバッグ用途を想定しています。
+//
+// DumpEvalContext renders the variables and functions visible from ctx in name order.
+// Values are rendered with DumpCTYValue, so values marked with SensitiveMark are redacted.
+// It is intended for debugging.
+func DumpEvalContext(ctx *hcl.EvalContext) string {
+	var b strings.Builder
+	variables := VisibleVariables(ctx)
+	b.WriteString("variables:\n")
+	for _, name := range sortedKeys(variables) {
+		fmt.Fprintf(&b, "  %s = %s\n", name, dumpVisibleValue(variables[name]))
+	}
+	functions := VisibleFunctions(ctx)
+	b.WriteString("functions:\n")
+	for _, name := range sortedKeys(functions) {
+		fmt.Fprintf(&b, "  %s\n", functionSignature(name, functions[name]))
+	}
+	return b.String()
+}
+
+func dumpVisibleValue(v cty.Value) string {
+	if !v.IsWhollyKnown() {
+		return "(unknown)"
+	}
+	s, err := DumpCTYValue(v)
+	if err != nil {
+		return fmt.Sprintf("(%s)", err)
+	}
+	return s
+}
+
+func functionSignature(name string, fn function.Function) string {
+	params := make([]string, 0, len(fn.Params())+1)
+	for _, p := range fn.Params() {
+		params = append(params, p.Name+" "+p.Type.FriendlyName())
+	}
+	if p := fn.VarParam(); p != nil {
+		params = append(params, p.Name+" ..."+p.Type.FriendlyName())
+	}
+	return name + "(" + strings.Join(params, ", ") + ")"
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,8 +4,12 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
 )
 
 func TestWithValue(t *testing.T) {
@@ -33,3 +37,132 @@ func TestWithValue(t *testing.T) {
 		t.Errorf("got: %#v, want: %#v", actual, expectd)
 	}
 }
+
+func TestVisibleVariables(t *testing.T) {
+	t.Parallel()
+	parent := hclutil.NewEvalContext()
+	parent.Variables = map[string]cty.Value{
+		"local": cty.ObjectVal(map[string]cty.Value{"x": cty.StringVal("parent")}),
+		"var":   cty.ObjectVal(map[string]cty.Value{"y": cty.StringVal("parent")}),
+	}
+	child := parent.NewChild()
+	child.Variables = map[string]cty.Value{
+		"local": cty.ObjectVal(map[string]cty.Value{"x": cty.StringVal("child")}),
+	}
+	child.Functions = map[string]function.Function{
+		"upper": stdlib.LowerFunc,
+	}
+	got := hclutil.VisibleVariables(child)
+	require.Len(t, got, 2)
+	require.True(t, got["local"].RawEquals(child.Variables["local"]))
+	require.True(t, got["var"].RawEquals(parent.Variables["var"]))
+
+	functions := hclutil.VisibleFunctions(child)
+	require.Equal(t, len(parent.Functions), len(functions))
+	v, err := functions["upper"].Call([]cty.Value{cty.StringVal("ABC")})
+	require.NoError(t, err)
+	require.Equal(t, cty.StringVal("abc"), v)
+}
+
+func TestDumpEvalContext(t *testing.T) {
+	t.Parallel()
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("secret").Mark(hclutil.SensitiveMark),
+			}),
+			"id": cty.UnknownVal(cty.String),
+		},
+		Functions: map[string]function.Function{
+			"format": stdlib.FormatFunc,
+			"upper":  stdlib.UpperFunc,
+		},
+	}
+	expected := `variables:
+  id = (unknown)
+  var = {"token":"(sensitive value)"}
+functions:
+  format(format string, args ...dynamic)
+  upper(str string)
+`
+	require.Equal(t, expected, hclutil.DumpEvalContext(ctx))
+}
+
+func TestGetSetValue(t *testing.T) {
+	t.Parallel()
+	ctx := hclutil.NewEvalContext()
+	ctx.Variables = map[string]cty.Value{
+		"servers": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("web"), "port": cty.NumberIntVal(80)}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("db"), "port": cty.NumberIntVal(5432)}),
+		}),
+		"tags": cty.MapVal(map[string]cty.Value{
+			"app.kubernetes.io/name": cty.StringVal("app"),
+		}),
+	}
+
+	v, diags := hclutil.GetValue(ctx, "servers[1].name")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.StringVal("db"), v)
+	v, diags = hclutil.GetValue(ctx, `tags["app.kubernetes.io/name"]`)
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.StringVal("app"), v)
+
+	ctx, diags = hclutil.SetValue(ctx, "servers[0].name", cty.StringVal("api"))
+	require.False(t, diags.HasErrors(), diags.Error())
+	ctx, diags = hclutil.SetValue(ctx, `tags["team"]`, cty.StringVal("infra"))
+	require.False(t, diags.HasErrors(), diags.Error())
+	ctx = hclutil.WithValue(ctx, "a.b.c", cty.StringVal("hoge"))
+
+	v, diags = hclutil.GetValue(ctx, "servers[0].name")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.StringVal("api"), v)
+	v, diags = hclutil.GetValue(ctx, "servers[0].port")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.True(t, v.RawEquals(cty.NumberIntVal(80)))
+	v, diags = hclutil.GetValue(ctx, "tags")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.MapVal(map[string]cty.Value{
+		"app.kubernetes.io/name": cty.StringVal("app"),
+		"team":                   cty.StringVal("infra"),
+	}), v)
+	v, diags = hclutil.GetValue(ctx, "a.b.c")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.StringVal("hoge"), v)
+
+	_, diags = hclutil.SetValue(ctx, "servers[5].name", cty.StringVal("x"))
+	require.EqualError(t, diags, "<value path>:1,8-11: Invalid value path; Cannot access servers[5]: the index 5 is out of range")
+	_, diags = hclutil.GetValue(ctx, "undefined.value")
+	require.True(t, diags.HasErrors())
+	_, diags = hclutil.GetValue(ctx, "servers[")
+	require.True(t, diags.HasErrors())
+}
+
+func TestWithoutValue(t *testing.T) {
+	t.Parallel()
+	parent := hclutil.NewEvalContext()
+	parent = hclutil.WithValue(parent, "local.a", cty.StringVal("a"))
+	parent = hclutil.WithValue(parent, "local.b", cty.StringVal("b"))
+	ctx := hclutil.WithValue(parent, "var.list", cty.ListVal([]cty.Value{cty.StringVal("x"), cty.StringVal("y")}))
+
+	ctx, diags := hclutil.WithoutValue(ctx, "local.a")
+	require.False(t, diags.HasErrors(), diags.Error())
+	ctx, diags = hclutil.WithoutValue(ctx, "var.list[0]")
+	require.False(t, diags.HasErrors(), diags.Error())
+	ctx, diags = hclutil.WithoutValue(ctx, "not_exists.value")
+	require.False(t, diags.HasErrors(), diags.Error())
+
+	_, diags = hclutil.GetValue(ctx, "local.a")
+	require.True(t, diags.HasErrors())
+	v, diags := hclutil.GetValue(ctx, "local.b")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.StringVal("b"), v)
+	v, diags = hclutil.GetValue(ctx, "var.list")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, cty.ListVal([]cty.Value{cty.StringVal("y")}), v)
+	require.NotEmpty(t, ctx.Functions)
+
+	ctx, diags = hclutil.WithoutValue(ctx, "local")
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.NotContains(t, hclutil.VisibleVariables(ctx), "local")
+}
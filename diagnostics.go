@@ -0,0 +1,382 @@
+package hclutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// DiagnosticsError は エラーを含む診断情報を出力したときに WriteDiagnostics が返すエラーです。
+// DiagnosticsError is the error returned by WriteDiagnostics when the diagnostics had errors.
+type DiagnosticsError struct {
+	Diagnostics hcl.Diagnostics
+}
+
+func (err *DiagnosticsError) Error() string {
+	return "diagnostics had errors, see above for details"
+}
+
+// ErrorCount は エラーの数を返します。
+// ErrorCount returns the number of errors.
+func (err *DiagnosticsError) ErrorCount() int {
+	return countDiagnostics(err.Diagnostics, hcl.DiagError)
+}
+
+// WarningCount は 警告の数を返します。
+// WarningCount returns the number of warnings.
+func (err *DiagnosticsError) WarningCount() int {
+	return countDiagnostics(err.Diagnostics, hcl.DiagWarning)
+}
+
+func countDiagnostics(diags hcl.Diagnostics, severity hcl.DiagnosticSeverity) int {
+	n := 0
+	for _, diag := range diags {
+		if diag.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// AddFile は 診断情報のソースコードの表示に使うファイルを登録します。
+// AddFile registers a file used to render source snippets of diagnostics.
+func (w *DiagnosticsWriter) AddFile(name string, file *hcl.File) {
+	w.filesMu.Lock()
+	defer w.filesMu.Unlock()
+	w.files[name] = file
+}
+
+// AddSource は 診断情報のソースコードの表示に使うソースコードを登録します。
+// AddSource registers a source used to render source snippets of diagnostics.
+func (w *DiagnosticsWriter) AddSource(name string, src []byte) {
+	w.AddFile(name, &hcl.File{Bytes: src})
+}
+
+// ParseExpression は HCLの式をパースし、ソースコードを登録します。式には "<expr#1>" のような一意な名前が付けられます。
+// ParseExpression parses a HCL expression and registers its source. The expression is given a unique name such as "<expr#1>".
+func (w *DiagnosticsWriter) ParseExpression(expr []byte) (hcl.Expression, hcl.Diagnostics) {
+	name := expressionFilename()
+	w.AddSource(name, expr)
+	return hclsyntax.ParseExpression(expr, name, hcl.Pos{Line: 1, Column: 1})
+}
+
+// expressionSeq は 式に付ける名前の連番です。式のソースコードは記録しません。
+var expressionSeq uint64
+
+// expressionFilename は ParseExpression で解析する式に付ける一意な名前を返します。
+func expressionFilename() string {
+	return fmt.Sprintf("<expr#%d>", atomic.AddUint64(&expressionSeq, 1))
+}
+
+// SetFormat は出力形式を設定します。
+// SetFormat sets the output format.
+func (w *DiagnosticsWriter) SetFormat(format DiagnosticsFormat) {
+	w.once = sync.Once{}
+	w.format = format
+}
+
+// Format は出力形式を返します。
+// Format returns the output format.
+func (w *DiagnosticsWriter) Format() DiagnosticsFormat {
+	return w.format
+}
+
+// SetIgnoreWarnings は警告を出力しないように設定します。
+// SetIgnoreWarnings sets whether to drop warnings.
+func (w *DiagnosticsWriter) SetIgnoreWarnings(ignore bool) {
+	w.ignoreWarnings = ignore
+}
+
+// IgnoreWarnings は警告を出力しないかどうかを返します。
+// IgnoreWarnings returns whether to drop warnings.
+func (w *DiagnosticsWriter) IgnoreWarnings() bool {
+	return w.ignoreWarnings
+}
+
+// SetWarningsAsErrors は警告をエラーとして扱うように設定します。SetIgnoreWarnings より優先されます。
+// ディレクトリの中で読み飛ばしたファイルの警告はエラーになりません。
+//
+// SetWarningsAsErrors sets whether to promote warnings to errors. It takes precedence over SetIgnoreWarnings.
+// Warnings about files skipped in a directory are not promoted.
+func (w *DiagnosticsWriter) SetWarningsAsErrors(promote bool) {
+	w.warningsAsErrors = promote
+}
+
+// WarningsAsErrors は警告をエラーとして扱うかどうかを返します。
+// WarningsAsErrors returns whether to promote warnings to errors.
+func (w *DiagnosticsWriter) WarningsAsErrors() bool {
+	return w.warningsAsErrors
+}
+
+// SetDeduplicate は Subjectの範囲とSummaryが同じ診断情報を1つにまとめるように設定します。
+// WriteDiagnostics を複数回呼び出した場合も、すでに出力した診断情報は ResetCounts を呼ぶまで出力されません。
+// 重複の除去は出力だけに影響し、WriteDiagnostics が返すエラーはまとめる前の診断情報から判断されます。
+//
+// SetDeduplicate sets whether to deduplicate diagnostics with the same subject range and summary.
+// Across multiple WriteDiagnostics calls, diagnostics already written are not written again until ResetCounts is called.
+// Deduplication only affects the output; the error returned by WriteDiagnostics is decided from the diagnostics before deduplication.
+func (w *DiagnosticsWriter) SetDeduplicate(deduplicate bool) {
+	w.deduplicate = deduplicate
+}
+
+// Deduplicate は診断情報をまとめるかどうかを返します。
+// Deduplicate returns whether to deduplicate diagnostics.
+func (w *DiagnosticsWriter) Deduplicate() bool {
+	return w.deduplicate
+}
+
+// SetSorted は診断情報をファイル名、行、列の順に並べ替えるように設定します。
+// SetSorted sets whether to sort diagnostics by file name, line and column.
+func (w *DiagnosticsWriter) SetSorted(sorted bool) {
+	w.sorted = sorted
+}
+
+// Sorted は診断情報を並べ替えるかどうかを返します。
+// Sorted returns whether to sort diagnostics.
+func (w *DiagnosticsWriter) Sorted() bool {
+	return w.sorted
+}
+
+// SetMaxDiagnostics は1回の WriteDiagnostics で出力する診断情報の最大数を設定します。0の場合は無制限です。
+// 出力されなかった診断情報の数は text と github 形式では末尾にまとめて、json 形式では {"omitted":{...}} の行として、
+// sarif 形式では run の properties として出力されます。
+//
+// SetMaxDiagnostics sets the maximum number of diagnostics written by one WriteDiagnostics call. 0 means unlimited.
+// The number of omitted diagnostics is summarized at the end in the text and github formats,
+// written as an {"omitted":{...}} line in the json format, and as run properties in the sarif format.
+func (w *DiagnosticsWriter) SetMaxDiagnostics(n int) {
+	w.maxDiagnostics = n
+}
+
+// MaxDiagnostics は出力する診断情報の最大数を返します。
+// MaxDiagnostics returns the maximum number of diagnostics to write.
+func (w *DiagnosticsWriter) MaxDiagnostics() int {
+	return w.maxDiagnostics
+}
+
+// Flush は 貯めておいた診断情報を出力します。
+// SARIF 形式は1つのログにすべての結果を含める必要があるため、WriteDiagnostics の結果を貯めておき Flush で出力します。
+// それ以外の形式では WriteDiagnostics がすぐに出力するため、何もしません。
+//
+// Flush writes the buffered diagnostics.
+// The SARIF format must contain all results in a single log, so the results of WriteDiagnostics are buffered and written by Flush.
+// Other formats write immediately in WriteDiagnostics, and Flush does nothing.
+func (w *DiagnosticsWriter) Flush() error {
+	type flusher interface {
+		Flush() error
+	}
+	if f, ok := w.diagsWriter.(flusher); ok {
+		w.filesMu.RLock()
+		defer w.filesMu.RUnlock()
+		return f.Flush()
+	}
+	return nil
+}
+
+func (w *DiagnosticsWriter) count(diags hcl.Diagnostics) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.diagFiles == nil {
+		w.diagFiles = make(map[string]struct{})
+	}
+	for _, diag := range diags {
+		switch diag.Severity {
+		case hcl.DiagError:
+			w.errorCount++
+		case hcl.DiagWarning:
+			w.warningCount++
+		}
+		if diag.Subject != nil {
+			w.diagFiles[diag.Subject.Filename] = struct{}{}
+		}
+	}
+}
+
+// ErrorCount は これまでに WriteDiagnostics で出力したエラーの累計を返します。
+// ErrorCount returns the cumulative number of errors written by WriteDiagnostics.
+func (w *DiagnosticsWriter) ErrorCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.errorCount
+}
+
+// WarningCount は これまでに WriteDiagnostics で出力した警告の累計を返します。
+// WarningCount returns the cumulative number of warnings written by WriteDiagnostics.
+func (w *DiagnosticsWriter) WarningCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.warningCount
+}
+
+// FileCount は これまでに WriteDiagnostics で出力した診断情報が対象とするファイルの数を返します。
+// FileCount returns the number of files referred to by the diagnostics written by WriteDiagnostics.
+func (w *DiagnosticsWriter) FileCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.diagFiles)
+}
+
+// ResetCounts は エラーと警告の累計と、重複の除去のために記録している出力済みの診断情報をリセットします。
+// ResetCounts resets the cumulative counts of errors and warnings, and the diagnostics remembered for deduplication.
+func (w *DiagnosticsWriter) ResetCounts() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errorCount = 0
+	w.warningCount = 0
+	w.diagFiles = nil
+	w.seen = nil
+}
+
+// WriteSummary は "2 errors, 3 warnings in 4 files" のような累計の要約を出力します。
+// text と github 形式でのみ出力され、json と sarif 形式では何も出力しません。
+//
+// WriteSummary writes a summary of the cumulative counts such as "2 errors, 3 warnings in 4 files".
+// It writes only in the text and github formats, and writes nothing in the json and sarif formats.
+func (w *DiagnosticsWriter) WriteSummary() error {
+	summary := fmt.Sprintf("%s, %s in %s",
+		pluralize(w.ErrorCount(), "error", "errors"),
+		pluralize(w.WarningCount(), "warning", "warnings"),
+		pluralize(w.FileCount(), "file", "files"),
+	)
+	var err error
+	switch w.format {
+	case DiagnosticsFormatText:
+		_, err = fmt.Fprintln(w.diagsOutput, summary)
+	case DiagnosticsFormatGitHubActions:
+		_, err = fmt.Fprintf(w.diagsOutput, "::notice::%s\n", githubActionsDataEscaper.Replace(summary))
+	}
+	return err
+}
+
+// expandFunctionCallDiagnostics は 関数呼び出しのエラーが診断情報であった場合(templatefile など)、その診断情報を直後に展開します。
+func expandFunctionCallDiagnostics(diags hcl.Diagnostics) hcl.Diagnostics {
+	expanded := make(hcl.Diagnostics, 0, len(diags))
+	for _, diag := range diags {
+		expanded = append(expanded, diag)
+		extra, ok := hcl.DiagnosticExtra[hclsyntax.FunctionCallDiagExtra](diag)
+		if !ok {
+			continue
+		}
+		var inner hcl.Diagnostics
+		if errors.As(extra.FunctionCallError(), &inner) {
+			expanded = append(expanded, expandFunctionCallDiagnostics(inner)...)
+		}
+	}
+	return expanded
+}
+
+// promoteDiagnostics は設定に従って警告の重要度の変更や除去を行います。WriteDiagnostics のエラーはこの結果から判断されます。
+func (w *DiagnosticsWriter) promoteDiagnostics(diags hcl.Diagnostics) hcl.Diagnostics {
+	promoted := make(hcl.Diagnostics, 0, len(diags))
+	for _, diag := range diags {
+		if diag.Severity == hcl.DiagWarning {
+			switch {
+			case w.warningsAsErrors && !isUnsupportedFileDiagnostic(diag):
+				d := *diag
+				d.Severity = hcl.DiagError
+				diag = &d
+			case w.ignoreWarnings:
+				continue
+			}
+		}
+		promoted = append(promoted, diag)
+	}
+	return promoted
+}
+
+// filterDiagnostics は設定に従って出力する診断情報の重複の除去と並べ替えを行います。
+func (w *DiagnosticsWriter) filterDiagnostics(diags hcl.Diagnostics) hcl.Diagnostics {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seen == nil {
+		w.seen = make(map[string]struct{}, len(diags))
+	}
+	filtered := make(hcl.Diagnostics, 0, len(diags))
+	for _, diag := range diags {
+		if w.deduplicate {
+			key := diag.Summary
+			if diag.Subject != nil {
+				key = diag.Subject.String() + ": " + key
+			}
+			if _, ok := w.seen[key]; ok {
+				continue
+			}
+			w.seen[key] = struct{}{}
+		}
+		filtered = append(filtered, diag)
+	}
+	if w.sorted {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return diagnosticLess(filtered[i], filtered[j])
+		})
+	}
+	return filtered
+}
+
+// diagnosticLess は Subject を持たない診断情報を末尾に、それ以外をファイル名、行、列の順に並べます。
+func diagnosticLess(a, b *hcl.Diagnostic) bool {
+	if a.Subject == nil || b.Subject == nil {
+		return a.Subject != nil && b.Subject == nil
+	}
+	if a.Subject.Filename != b.Subject.Filename {
+		return a.Subject.Filename < b.Subject.Filename
+	}
+	if a.Subject.Start.Line != b.Subject.Start.Line {
+		return a.Subject.Start.Line < b.Subject.Start.Line
+	}
+	return a.Subject.Start.Column < b.Subject.Start.Column
+}
+
+func (w *DiagnosticsWriter) writeOmitted(omitted hcl.Diagnostics) {
+	var errs, warns int
+	for _, diag := range omitted {
+		if diag.Severity == hcl.DiagError {
+			errs++
+		} else {
+			warns++
+		}
+	}
+	counts := make([]string, 0, 2)
+	if errs > 0 {
+		counts = append(counts, pluralize(errs, "more error", "more errors"))
+	}
+	if warns > 0 {
+		counts = append(counts, pluralize(warns, "more warning", "more warnings"))
+	}
+	message := "... and " + strings.Join(counts, ", ")
+	switch w.format {
+	case DiagnosticsFormatText:
+		fmt.Fprintln(w.diagsOutput, message)
+	case DiagnosticsFormatGitHubActions:
+		fmt.Fprintf(w.diagsOutput, "::notice::%s\n", githubActionsDataEscaper.Replace(message))
+	case DiagnosticsFormatJSON:
+		json.NewEncoder(w.diagsOutput).Encode(jsonOmitted{
+			Omitted: jsonOmittedCounts{Errors: errs, Warnings: warns},
+		})
+	case DiagnosticsFormatSARIF:
+		if sw, ok := w.diagsWriter.(*sarifDiagnosticWriter); ok {
+			sw.omittedErrors += errs
+			sw.omittedWarnings += warns
+		}
+	}
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// NewDiagnosticsWriter は ファイルが登録されていない DiagnosticsWriter を作成します。出力先は標準エラー出力です。
+// NewDiagnosticsWriter creates a DiagnosticsWriter without any files. The output is os.Stderr.
+func NewDiagnosticsWriter() *DiagnosticsWriter {
+	return newDiagnosticsWriter(nil)
+}
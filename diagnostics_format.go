@@ -0,0 +1,381 @@
+package hclutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// DiagnosticsFormat は DiagnosticsWriter の出力形式です。
+// DiagnosticsFormat is the output format of DiagnosticsWriter.
+type DiagnosticsFormat int
+
+const (
+	// DiagnosticsFormatText は hcl.NewDiagnosticTextWriter による人間向けの形式です。
+	// DiagnosticsFormatText is the human readable format of hcl.NewDiagnosticTextWriter.
+	DiagnosticsFormatText DiagnosticsFormat = iota
+	// DiagnosticsFormatJSON は 1行に1つの診断情報をJSONで出力する形式です。
+	// DiagnosticsFormatJSON writes one JSON object per diagnostic per line.
+	DiagnosticsFormatJSON
+	// DiagnosticsFormatSARIF は SARIF 2.1.0 のログを出力する形式です。
+	// WriteDiagnostics の結果は貯めておかれ、DiagnosticsWriter.Flush で1つのログとしてまとめて出力されます。
+	// DiagnosticsFormatSARIF writes a SARIF 2.1.0 log.
+	// The results of WriteDiagnostics are buffered and written as a single log by DiagnosticsWriter.Flush.
+	DiagnosticsFormatSARIF
+	// DiagnosticsFormatGitHubActions は GitHub Actions のワークフローコマンド(::error file=...::)で出力する形式です。
+	// DiagnosticsFormatGitHubActions writes GitHub Actions workflow commands (::error file=...::).
+	DiagnosticsFormatGitHubActions
+)
+
+var diagnosticsFormatNames = map[DiagnosticsFormat]string{
+	DiagnosticsFormatText:          "text",
+	DiagnosticsFormatJSON:          "json",
+	DiagnosticsFormatSARIF:         "sarif",
+	DiagnosticsFormatGitHubActions: "github",
+}
+
+func (f DiagnosticsFormat) String() string {
+	if name, ok := diagnosticsFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("DiagnosticsFormat(%d)", int(f))
+}
+
+// ParseDiagnosticsFormat は 文字列(text, json, sarif, github)を DiagnosticsFormat に変換します。
+// ParseDiagnosticsFormat converts a string (text, json, sarif, github) to DiagnosticsFormat.
+func ParseDiagnosticsFormat(str string) (DiagnosticsFormat, error) {
+	for format, name := range diagnosticsFormatNames {
+		if strings.EqualFold(name, str) {
+			return format, nil
+		}
+	}
+	return DiagnosticsFormatText, fmt.Errorf("unknown diagnostics format `%s`", str)
+}
+
+type jsonDiagnostic struct {
+	Severity string       `json:"severity"`
+	Summary  string       `json:"summary"`
+	Detail   string       `json:"detail,omitempty"`
+	Range    *jsonRange   `json:"range,omitempty"`
+	Context  *jsonRange   `json:"context,omitempty"`
+	Snippet  *jsonSnippet `json:"snippet,omitempty"`
+}
+
+type jsonRange struct {
+	Filename string  `json:"filename"`
+	Start    jsonPos `json:"start"`
+	End      jsonPos `json:"end"`
+}
+
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+type jsonSnippet struct {
+	Context              string `json:"context,omitempty"`
+	Code                 string `json:"code"`
+	StartLine            int    `json:"start_line"`
+	HighlightStartOffset int    `json:"highlight_start_offset"`
+	HighlightEndOffset   int    `json:"highlight_end_offset"`
+}
+
+func newJSONRange(rng *hcl.Range) *jsonRange {
+	if rng == nil {
+		return nil
+	}
+	return &jsonRange{
+		Filename: rng.Filename,
+		Start:    jsonPos{Line: rng.Start.Line, Column: rng.Start.Column, Byte: rng.Start.Byte},
+		End:      jsonPos{Line: rng.End.Line, Column: rng.End.Column, Byte: rng.End.Byte},
+	}
+}
+
+func newJSONDiagnostic(diag *hcl.Diagnostic, files map[string]*hcl.File) *jsonDiagnostic {
+	return &jsonDiagnostic{
+		Severity: diagnosticSeverityString(diag.Severity),
+		Summary:  diag.Summary,
+		Detail:   diag.Detail,
+		Range:    newJSONRange(diag.Subject),
+		Context:  newJSONRange(diag.Context),
+		Snippet:  newJSONSnippet(diag, files),
+	}
+}
+
+// newJSONSnippet は 診断情報の Context(なければ Subject) の範囲のソースコードを切り出します。
+func newJSONSnippet(diag *hcl.Diagnostic, files map[string]*hcl.File) *jsonSnippet {
+	if diag.Subject == nil {
+		return nil
+	}
+	file, ok := files[diag.Subject.Filename]
+	if !ok || file == nil || file.Bytes == nil {
+		return nil
+	}
+	rng := *diag.Subject
+	if diag.Context != nil {
+		rng = hcl.RangeOver(rng, *diag.Context)
+	}
+	src := file.Bytes
+	start := rng.Start.Byte
+	if start > len(src) {
+		return nil
+	}
+	end := rng.End.Byte
+	if end > len(src) {
+		end = len(src)
+	}
+	// 範囲を含む行全体を切り出す
+	codeStart := bytes.LastIndexByte(src[:start], '\n') + 1
+	codeEnd := len(src)
+	if i := bytes.IndexByte(src[end:], '\n'); i >= 0 {
+		codeEnd = end + i
+	}
+	if end > codeStart && src[end-1] == '\n' {
+		codeEnd = end - 1
+	}
+	snippet := &jsonSnippet{
+		Code:                 strings.TrimSuffix(string(src[codeStart:codeEnd]), "\r"),
+		StartLine:            rng.Start.Line,
+		HighlightStartOffset: diag.Subject.Start.Byte - codeStart,
+		HighlightEndOffset:   diag.Subject.End.Byte - codeStart,
+	}
+	if snippet.HighlightEndOffset > len(snippet.Code) {
+		snippet.HighlightEndOffset = len(snippet.Code)
+	}
+	type contextStringer interface {
+		ContextString(offset int) string
+	}
+	if cs, ok := file.Nav.(contextStringer); ok {
+		snippet.Context = cs.ContextString(diag.Subject.Start.Byte)
+	}
+	return snippet
+}
+
+func diagnosticSeverityString(severity hcl.DiagnosticSeverity) string {
+	switch severity {
+	case hcl.DiagError:
+		return "error"
+	case hcl.DiagWarning:
+		return "warning"
+	default:
+		return "invalid"
+	}
+}
+
+// jsonOmitted は SetMaxDiagnostics で出力されなかった診断情報の数を示す行です。
+type jsonOmitted struct {
+	Omitted jsonOmittedCounts `json:"omitted"`
+}
+
+type jsonOmittedCounts struct {
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+}
+
+type jsonDiagnosticWriter struct {
+	wr    io.Writer
+	files map[string]*hcl.File
+}
+
+func (w *jsonDiagnosticWriter) WriteDiagnostic(diag *hcl.Diagnostic) error {
+	return json.NewEncoder(w.wr).Encode(newJSONDiagnostic(diag, w.files))
+}
+
+func (w *jsonDiagnosticWriter) WriteDiagnostics(diags hcl.Diagnostics) error {
+	for _, diag := range diags {
+		if err := w.WriteDiagnostic(diag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool        `json:"tool"`
+	Results    []sarifResult    `json:"results"`
+	Properties *sarifProperties `json:"properties,omitempty"`
+}
+
+// sarifProperties は SetMaxDiagnostics で出力されなかった診断情報の数です。
+type sarifProperties struct {
+	OmittedErrors   int `json:"omittedErrors"`
+	OmittedWarnings int `json:"omittedWarnings"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int           `json:"startLine"`
+	StartColumn int           `json:"startColumn"`
+	EndLine     int           `json:"endLine"`
+	EndColumn   int           `json:"endColumn"`
+	Snippet     *sarifMessage `json:"snippet,omitempty"`
+}
+
+// sarifDiagnosticWriter は 診断情報を結果として貯めておき、Flush でまとめて1つの SARIF ログとして出力します。
+type sarifDiagnosticWriter struct {
+	wr      io.Writer
+	files   map[string]*hcl.File
+	results []sarifResult
+
+	omittedErrors   int
+	omittedWarnings int
+}
+
+func (w *sarifDiagnosticWriter) WriteDiagnostic(diag *hcl.Diagnostic) error {
+	return w.WriteDiagnostics(hcl.Diagnostics{diag})
+}
+
+func (w *sarifDiagnosticWriter) WriteDiagnostics(diags hcl.Diagnostics) error {
+	for _, diag := range diags {
+		level := "error"
+		if diag.Severity == hcl.DiagWarning {
+			level = "warning"
+		}
+		message := diag.Summary
+		if diag.Detail != "" {
+			message += ": " + diag.Detail
+		}
+		result := sarifResult{
+			RuleID:  diag.Summary,
+			Level:   level,
+			Message: sarifMessage{Text: message},
+		}
+		if diag.Subject != nil {
+			region := sarifRegion{
+				StartLine:   diag.Subject.Start.Line,
+				StartColumn: diag.Subject.Start.Column,
+				EndLine:     diag.Subject.End.Line,
+				EndColumn:   diag.Subject.End.Column,
+			}
+			if snippet := newJSONSnippet(diag, w.files); snippet != nil {
+				region.Snippet = &sarifMessage{Text: snippet.Code}
+			}
+			result.Locations = []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: diag.Subject.Filename},
+					Region:           region,
+				},
+			}}
+		}
+		w.results = append(w.results, result)
+	}
+	return nil
+}
+
+// Flush は これまでの結果を1つの SARIF ログとして出力し、結果を空にします。
+func (w *sarifDiagnosticWriter) Flush() error {
+	results := w.results
+	if results == nil {
+		results = []sarifResult{}
+	}
+	w.results = nil
+	var props *sarifProperties
+	if w.omittedErrors > 0 || w.omittedWarnings > 0 {
+		props = &sarifProperties{OmittedErrors: w.omittedErrors, OmittedWarnings: w.omittedWarnings}
+	}
+	w.omittedErrors, w.omittedWarnings = 0, 0
+	enc := json.NewEncoder(w.wr)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "hclutil",
+				InformationURI: "https://github.com/mashiike/hclutil",
+			}},
+			Results:    results,
+			Properties: props,
+		}},
+	})
+}
+
+type githubActionsDiagnosticWriter struct {
+	wr io.Writer
+}
+
+var (
+	githubActionsDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	githubActionsPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func (w *githubActionsDiagnosticWriter) WriteDiagnostic(diag *hcl.Diagnostic) error {
+	command := "error"
+	if diag.Severity == hcl.DiagWarning {
+		command = "warning"
+	}
+	props := make([]string, 0, 6)
+	if diag.Subject != nil {
+		props = append(props,
+			"file="+githubActionsPropertyEscaper.Replace(diag.Subject.Filename),
+			fmt.Sprintf("line=%d", diag.Subject.Start.Line),
+			fmt.Sprintf("col=%d", diag.Subject.Start.Column),
+			fmt.Sprintf("endLine=%d", diag.Subject.End.Line),
+			fmt.Sprintf("endColumn=%d", diag.Subject.End.Column),
+		)
+	}
+	props = append(props, "title="+githubActionsPropertyEscaper.Replace(diag.Summary))
+	message := diag.Detail
+	if message == "" {
+		message = diag.Summary
+	}
+	_, err := fmt.Fprintf(w.wr, "::%s %s::%s\n", command, strings.Join(props, ","), githubActionsDataEscaper.Replace(message))
+	return err
+}
+
+func (w *githubActionsDiagnosticWriter) WriteDiagnostics(diags hcl.Diagnostics) error {
+	for _, diag := range diags {
+		if err := w.WriteDiagnostic(diag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package hclutil_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+)
+
+func testDiagnostics(body hcl.Body) hcl.Diagnostics {
+	attrs, _ := body.JustAttributes()
+	return hcl.Diagnostics{
+		{
+			Severity: hcl.DiagError,
+			Summary:  "test error",
+			Detail:   "test error detail",
+			Subject:  attrs["text"].Expr.Range().Ptr(),
+		},
+		{
+			Severity: hcl.DiagWarning,
+			Summary:  "test warning",
+			Detail:   "test warning, detail\nsecond line",
+		},
+	}
+}
+
+func TestDiagnosticsWriter__JSON(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	writer.SetFormat(hclutil.DiagnosticsFormatJSON)
+	err := writer.WriteDiagnostics(testDiagnostics(body))
+	require.Error(t, err)
+	require.Equal(t, `{"severity":"error","summary":"test error","detail":"test error detail","range":{"filename":"testdata/hcl_file.hcl","start":{"line":1,"column":8,"byte":7},"end":{"line":1,"column":14,"byte":13}},"snippet":{"code":"text = \"hoge\"","start_line":1,"highlight_start_offset":7,"highlight_end_offset":13}}
+{"severity":"warning","summary":"test warning","detail":"test warning, detail\nsecond line"}
+`, buf.String())
+}
+
+func TestDiagnosticsWriter__SARIF(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	writer.SetFormat(hclutil.DiagnosticsFormatSARIF)
+	err := writer.WriteDiagnostics(testDiagnostics(body))
+	require.Error(t, err)
+	err = writer.WriteDiagnostics(testDiagnostics(body)[1:])
+	require.NoError(t, err)
+	require.Empty(t, buf.String())
+	require.NoError(t, writer.Flush())
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine   int `json:"startLine"`
+							StartColumn int `json:"startColumn"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	dec := json.NewDecoder(&buf)
+	require.NoError(t, dec.Decode(&log))
+	require.False(t, dec.More(), "expected a single SARIF log")
+	require.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	require.Len(t, log.Runs[0].Results, 3)
+	result := log.Runs[0].Results[0]
+	require.Equal(t, "test error", result.RuleID)
+	require.Equal(t, "error", result.Level)
+	require.Len(t, result.Locations, 1)
+	require.Equal(t, "testdata/hcl_file.hcl", result.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	require.Equal(t, 1, result.Locations[0].PhysicalLocation.Region.StartLine)
+	require.Equal(t, 8, result.Locations[0].PhysicalLocation.Region.StartColumn)
+	require.Equal(t, "warning", log.Runs[0].Results[1].Level)
+}
+
+func TestDiagnosticsWriter__GitHubActions(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	format, err := hclutil.ParseDiagnosticsFormat("github")
+	require.NoError(t, err)
+	writer.SetFormat(format)
+	err = writer.WriteDiagnostics(testDiagnostics(body))
+	require.Error(t, err)
+	require.Equal(t, "::error file=testdata/hcl_file.hcl,line=1,col=8,endLine=1,endColumn=14,title=test error::test error detail\n"+
+		"::warning title=test warning::test warning, detail%0Asecond line\n", buf.String())
+}
+
+func TestDiagnosticsWriter__Filter(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	attrs, _ := body.JustAttributes()
+	newDiag := func(severity hcl.DiagnosticSeverity, summary string, attr string) *hcl.Diagnostic {
+		return &hcl.Diagnostic{
+			Severity: severity,
+			Summary:  summary,
+			Subject:  attrs[attr].Expr.Range().Ptr(),
+		}
+	}
+	input := hcl.Diagnostics{
+		newDiag(hcl.DiagError, "number error", "number"),
+		newDiag(hcl.DiagWarning, "text warning", "text"),
+		newDiag(hcl.DiagError, "text error", "text"),
+		newDiag(hcl.DiagError, "number error", "number"),
+		newDiag(hcl.DiagError, "boolean error", "boolean"),
+	}
+
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	writer.SetFormat(hclutil.DiagnosticsFormatGitHubActions)
+	writer.SetDeduplicate(true)
+	writer.SetSorted(true)
+	writer.SetIgnoreWarnings(true)
+	require.Error(t, writer.WriteDiagnostics(input))
+	require.Equal(t, "::error file=testdata/hcl_file.hcl,line=1,col=8,endLine=1,endColumn=14,title=text error::text error\n"+
+		"::error file=testdata/hcl_file.hcl,line=2,col=10,endLine=2,endColumn=13,title=number error::number error\n"+
+		"::error file=testdata/hcl_file.hcl,line=3,col=11,endLine=3,endColumn=15,title=boolean error::boolean error\n", buf.String())
+
+	// すでに出力した診断情報は、次の WriteDiagnostics でも出力されないが、エラーは返される
+	buf.Reset()
+	require.Error(t, writer.WriteDiagnostics(input))
+	require.Empty(t, buf.String())
+
+	buf.Reset()
+	writer.ResetCounts()
+	writer.SetIgnoreWarnings(false)
+	writer.SetMaxDiagnostics(2)
+	require.Error(t, writer.WriteDiagnostics(input))
+	require.Equal(t, "::warning file=testdata/hcl_file.hcl,line=1,col=8,endLine=1,endColumn=14,title=text warning::text warning\n"+
+		"::error file=testdata/hcl_file.hcl,line=1,col=8,endLine=1,endColumn=14,title=text error::text error\n"+
+		"::notice::... and 2 more errors\n", buf.String())
+
+	buf.Reset()
+	writer.ResetCounts()
+	writer.SetMaxDiagnostics(0)
+	writer.SetWarningsAsErrors(true)
+	require.Error(t, writer.WriteDiagnostics(hcl.Diagnostics{newDiag(hcl.DiagWarning, "text warning", "text")}))
+	require.Equal(t, "::error file=testdata/hcl_file.hcl,line=1,col=8,endLine=1,endColumn=14,title=text warning::text warning\n", buf.String())
+}
+
+func TestDiagnosticsWriter__DeduplicateSameErrorTwice(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	attrs, _ := body.JustAttributes()
+	input := hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "number error",
+		Subject:  attrs["number"].Expr.Range().Ptr(),
+	}}
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	writer.SetFormat(hclutil.DiagnosticsFormatGitHubActions)
+	writer.SetDeduplicate(true)
+	for i := 0; i < 2; i++ {
+		err := writer.WriteDiagnostics(input)
+		var diagsErr *hclutil.DiagnosticsError
+		require.ErrorAs(t, err, &diagsErr, "write #%d", i+1)
+		require.Equal(t, 1, diagsErr.ErrorCount())
+	}
+	require.Equal(t, 1, strings.Count(buf.String(), "number error::"))
+	require.Equal(t, 1, writer.ErrorCount())
+}
+
+func TestDiagnosticsWriter__Summary(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	err := writer.WriteDiagnostics(testDiagnostics(body))
+	var diagsErr *hclutil.DiagnosticsError
+	require.ErrorAs(t, err, &diagsErr)
+	require.Equal(t, 1, diagsErr.ErrorCount())
+	require.Equal(t, 1, diagsErr.WarningCount())
+	require.Len(t, diagsErr.Diagnostics, 2)
+
+	require.NoError(t, writer.WriteDiagnostics(hcl.Diagnostics{{
+		Severity: hcl.DiagWarning,
+		Summary:  "another warning",
+	}}))
+	require.Equal(t, 1, writer.ErrorCount())
+	require.Equal(t, 2, writer.WarningCount())
+	require.Equal(t, 1, writer.FileCount())
+
+	buf.Reset()
+	require.NoError(t, writer.WriteSummary())
+	require.Equal(t, "1 error, 2 warnings in 1 file\n", buf.String())
+
+	writer.ResetCounts()
+	require.Equal(t, 0, writer.ErrorCount())
+	require.Equal(t, 0, writer.WarningCount())
+}
+
+func TestDiagnosticsWriter__ParseExpression(t *testing.T) {
+	t.Parallel()
+	writer := hclutil.NewDiagnosticsWriter()
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	first, diags := writer.ParseExpression([]byte(`upper(local.hoge)`))
+	diagsReport(t, diags)
+	second, diags := writer.ParseExpression([]byte(`lower(local.fuga)`))
+	diagsReport(t, diags)
+	require.NotEqual(t, first.Range().Filename, second.Range().Filename)
+
+	_, diags = first.Value(nil)
+	require.Error(t, writer.WriteDiagnostics(diags))
+	require.Contains(t, buf.String(), "on "+first.Range().Filename+" line 1:\n   1: upper(local.hoge)\n")
+
+	// パッケージの ParseExpression はソースコードを記録しないため、表示するには AddSource で登録する
+	buf.Reset()
+	src := []byte(`trimspace(local.piyo)`)
+	third, diags := hclutil.ParseExpression(src)
+	diagsReport(t, diags)
+	require.NotEqual(t, second.Range().Filename, third.Range().Filename)
+	_, diags = third.Value(nil)
+	require.Error(t, writer.WriteDiagnostics(diags))
+	require.NotContains(t, buf.String(), "trimspace(local.piyo)")
+
+	buf.Reset()
+	writer.AddSource(third.Range().Filename, src)
+	require.Error(t, writer.WriteDiagnostics(diags))
+	require.Contains(t, buf.String(), "on "+third.Range().Filename+" line 1:\n   1: trimspace(local.piyo)\n")
+}
+
+func TestDiagnosticsWriter__TemplateFile(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"body.tmpl": {
+			Data: []byte("hello\n${undefined}\n"),
+		},
+	}
+	writer := hclutil.NewDiagnosticsWriter()
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	expr, diags := writer.ParseExpression([]byte(`templatefile("body.tmpl", {})`))
+	diagsReport(t, diags)
+	_, diags = expr.Value(hclutil.NewEvalContext(hclutil.WithFS(testFs), hclutil.WithDiagnosticsWriter(writer)))
+	require.Error(t, writer.WriteDiagnostics(diags))
+	require.Contains(t, buf.String(), "on body.tmpl line 2:\n   2: ${undefined}\n")
+	require.ElementsMatch(t, []string{expr.Range().Filename, "body.tmpl"}, writer.Files())
+}
+
+func TestDiagnosticsWriter__FileFunctions(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"data.txt": {
+			Data: []byte("hello"),
+		},
+		"body.gotmpl": {
+			Data: []byte("{{ .name }}"),
+		},
+	}
+	writer := hclutil.NewDiagnosticsWriter()
+	expr, diags := writer.ParseExpression([]byte(`[file("data.txt"), gotemplatefile("body.gotmpl", { name = "hoge" })]`))
+	diagsReport(t, diags)
+	_, diags = expr.Value(hclutil.NewEvalContext(hclutil.WithFS(testFs), hclutil.WithDiagnosticsWriter(writer)))
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []string{expr.Range().Filename, "data.txt", "body.gotmpl"}, writer.Files())
+}
+
+func TestDiagnosticsWriter__OmittedJSONAndSARIF(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/hcl_file.hcl")
+	diagsReport(t, diags)
+	input := append(testDiagnostics(body), &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "another error",
+	})
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	writer.SetFormat(hclutil.DiagnosticsFormatJSON)
+	writer.SetMaxDiagnostics(1)
+	require.Error(t, writer.WriteDiagnostics(input))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.JSONEq(t, `{"omitted":{"errors":1,"warnings":1}}`, lines[1])
+
+	buf.Reset()
+	writer.SetFormat(hclutil.DiagnosticsFormatSARIF)
+	require.Error(t, writer.WriteDiagnostics(input))
+	require.NoError(t, writer.Flush())
+	var log struct {
+		Runs []struct {
+			Results    []json.RawMessage `json:"results"`
+			Properties struct {
+				OmittedErrors   int `json:"omittedErrors"`
+				OmittedWarnings int `json:"omittedWarnings"`
+			} `json:"properties"`
+		} `json:"runs"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	require.Len(t, log.Runs, 1)
+	require.Len(t, log.Runs[0].Results, 1)
+	require.Equal(t, 1, log.Runs[0].Properties.OmittedErrors)
+	require.Equal(t, 1, log.Runs[0].Properties.OmittedWarnings)
+}
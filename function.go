@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,7 +14,6 @@ import (
 	"github.com/Songmu/flextime"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/ext/tryfunc"
-	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/lestrrat-go/strftime"
 	ctyyaml "github.com/zclconf/go-cty-yaml"
 	"github.com/zclconf/go-cty/cty"
@@ -22,7 +22,11 @@ import (
 )
 
 type utilFunctionOptions struct {
-	fsyss []fs.FS
+	fsyss                []fs.FS
+	envAllowPatterns     []string
+	envSensitivePatterns []string
+	templateMaxDepth     int
+	diagsWriter          *DiagnosticsWriter
 }
 
 // WithFilePath は file関数やtemplatefile関数で参照するファイルのパスを追加します。
@@ -39,133 +43,229 @@ func WithFS(baseFS fs.FS) func(*utilFunctionOptions) {
 	}
 }
 
+// WithAllowedEnv は env関数やmust_env関数で参照できる環境変数を制限します。
+// パターンは path.Match の形式で指定します。例えば "APP_*" は APP_ で始まる環境変数のみを許可します。
+// 一つも指定されていない場合は、すべての環境変数を参照できます。
+//
+// WithAllowedEnv restricts the environment variables that env and must_env can read.
+// Patterns use the path.Match syntax, e.g. "APP_*" allows only variables prefixed with APP_.
+// If no pattern is given, every environment variable is readable.
+func WithAllowedEnv(patterns ...string) func(*utilFunctionOptions) {
+	return func(opts *utilFunctionOptions) {
+		opts.envAllowPatterns = append(opts.envAllowPatterns, patterns...)
+	}
+}
+
+// WithSensitiveEnv は 指定したパターンに一致する環境変数の値に SensitiveMark を付与します。
+// パターンは path.Match の形式で指定します。例えば "*_SECRET" や "*_TOKEN" です。
+//
+// WithSensitiveEnv marks the values of environment variables matching the patterns with SensitiveMark.
+// Patterns use the path.Match syntax, e.g. "*_SECRET" or "*_TOKEN".
+func WithSensitiveEnv(patterns ...string) func(*utilFunctionOptions) {
+	return func(opts *utilFunctionOptions) {
+		opts.envSensitivePatterns = append(opts.envSensitivePatterns, patterns...)
+	}
+}
+
+// WithTemplateMaxDepth は templatefile関数のインクルードの最大の深さを設定します。デフォルトは10です。
+// WithTemplateMaxDepth sets the maximum include depth of the templatefile function. The default is 10.
+func WithTemplateMaxDepth(depth int) func(*utilFunctionOptions) {
+	return func(opts *utilFunctionOptions) {
+		opts.templateMaxDepth = depth
+	}
+}
+
+// WithDiagnosticsWriter は templatefile, gotemplatefile, file関数で読み込んだファイルのソースコードを DiagnosticsWriter に登録します。
+// これにより、テンプレートの診断情報にもソースコードが表示されます。
+//
+// WithDiagnosticsWriter registers the sources of files loaded by the templatefile, gotemplatefile and file functions with the DiagnosticsWriter,
+// so that diagnostics in templates are rendered with source snippets.
+func WithDiagnosticsWriter(w *DiagnosticsWriter) func(*utilFunctionOptions) {
+	return func(opts *utilFunctionOptions) {
+		opts.diagsWriter = w
+	}
+}
+
 // WithUtilFunctions は よく使う基本的な関数を登録したEvalContextを作成します。
 func WithUtilFunctions(ctx *hcl.EvalContext, optFns ...func(*utilFunctionOptions)) *hcl.EvalContext {
 	opts := &utilFunctionOptions{}
 	for _, optFn := range optFns {
 		optFn(opts)
 	}
-	f := map[string]function.Function{
-		"abs":              stdlib.AbsoluteFunc,
-		"add":              stdlib.AddFunc,
-		"can":              tryfunc.CanFunc,
-		"ceil":             stdlib.CeilFunc,
-		"chomp":            stdlib.ChompFunc,
-		"coalesce":         stdlib.CoalesceFunc,
-		"coalescelist":     stdlib.CoalesceListFunc,
-		"compact":          stdlib.CompactFunc,
-		"concat":           stdlib.ConcatFunc,
-		"contains":         stdlib.ContainsFunc,
-		"csvdecode":        stdlib.CSVDecodeFunc,
-		"duration":         DurationFunc,
-		"distinct":         stdlib.DistinctFunc,
-		"element":          stdlib.ElementFunc,
-		"env":              EnvFunc,
-		"chunklist":        stdlib.ChunklistFunc,
-		"flatten":          stdlib.FlattenFunc,
-		"floor":            stdlib.FloorFunc,
-		"format":           stdlib.FormatFunc,
-		"formatdate":       stdlib.FormatDateFunc,
-		"formatlist":       stdlib.FormatListFunc,
-		"indent":           stdlib.IndentFunc,
-		"index":            stdlib.IndexFunc,
-		"join":             stdlib.JoinFunc,
-		"jsondecode":       stdlib.JSONDecodeFunc,
-		"jsonencode":       stdlib.JSONEncodeFunc,
-		"keys":             stdlib.KeysFunc,
-		"log":              stdlib.LogFunc,
-		"lower":            stdlib.LowerFunc,
-		"max":              stdlib.MaxFunc,
-		"merge":            stdlib.MergeFunc,
-		"min":              stdlib.MinFunc,
-		"must_env":         MustEnvFunc,
-		"now":              NowFunc,
-		"parseint":         stdlib.ParseIntFunc,
-		"pow":              stdlib.PowFunc,
-		"range":            stdlib.RangeFunc,
-		"regex":            stdlib.RegexFunc,
-		"regexall":         stdlib.RegexAllFunc,
-		"reverse":          stdlib.ReverseListFunc,
-		"setintersection":  stdlib.SetIntersectionFunc,
-		"setproduct":       stdlib.SetProductFunc,
-		"setsubtract":      stdlib.SetSubtractFunc,
-		"setunion":         stdlib.SetUnionFunc,
-		"signum":           stdlib.SignumFunc,
-		"strftime":         StrftimeFunc,
-		"strftime_in_zone": StrftimeInZoneFunc,
-		"slice":            stdlib.SliceFunc,
-		"sort":             stdlib.SortFunc,
-		"split":            stdlib.SplitFunc,
-		"strrev":           stdlib.ReverseFunc,
-		"substr":           stdlib.SubstrFunc,
-		"timeadd":          stdlib.TimeAddFunc,
-		"title":            stdlib.TitleFunc,
-		"trim":             stdlib.TrimFunc,
-		"trimprefix":       stdlib.TrimPrefixFunc,
-		"trimspace":        stdlib.TrimSpaceFunc,
-		"trimsuffix":       stdlib.TrimSuffixFunc,
-		"try":              tryfunc.TryFunc,
-		"upper":            stdlib.UpperFunc,
-		"values":           stdlib.ValuesFunc,
-		"yamldecode":       ctyyaml.YAMLDecodeFunc,
-		"yamlencode":       ctyyaml.YAMLEncodeFunc,
-		"zipmap":           stdlib.ZipmapFunc,
-	}
-	f["file"] = MakeFileFunc(opts.fsyss...)
-	f["templatefile"] = MakeTemplateFileFunc(f, opts.fsyss...)
 	ret := ctx.NewChild()
-	ret.Functions = f
+	ret.Functions = utilFunctions(opts)
 	return ret
 }
 
-var MustEnvFunc = function.New(&function.Spec{
-	Params: []function.Parameter{
-		{
-			Name:        "key",
-			Type:        cty.String,
-			AllowMarked: true,
-		},
-	},
-	Type: function.StaticReturnType(cty.String),
-	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-		keyArg, keyMarks := args[0].Unmark()
-		key := keyArg.AsString()
-		value := os.Getenv(key)
-		if value == "" {
-			err := function.NewArgError(0, fmt.Errorf("env `%s` is not set", key))
-			return cty.UnknownVal(cty.String), err
+func utilFunctions(opts *utilFunctionOptions) map[string]function.Function {
+	f := map[string]function.Function{
+		"abs":                   stdlib.AbsoluteFunc,
+		"add":                   stdlib.AddFunc,
+		"can":                   tryfunc.CanFunc,
+		"ceil":                  stdlib.CeilFunc,
+		"chomp":                 stdlib.ChompFunc,
+		"coalesce":              stdlib.CoalesceFunc,
+		"coalescelist":          stdlib.CoalesceListFunc,
+		"compact":               stdlib.CompactFunc,
+		"concat":                stdlib.ConcatFunc,
+		"contains":              stdlib.ContainsFunc,
+		"csvdecode":             stdlib.CSVDecodeFunc,
+		"duration":              DurationFunc,
+		"distinct":              stdlib.DistinctFunc,
+		"element":               stdlib.ElementFunc,
+		"chunklist":             stdlib.ChunklistFunc,
+		"flatten":               stdlib.FlattenFunc,
+		"floor":                 stdlib.FloorFunc,
+		"format":                stdlib.FormatFunc,
+		"formatdate":            stdlib.FormatDateFunc,
+		"formatlist":            stdlib.FormatListFunc,
+		"indent":                stdlib.IndentFunc,
+		"index":                 stdlib.IndexFunc,
+		"join":                  stdlib.JoinFunc,
+		"jsondecode":            stdlib.JSONDecodeFunc,
+		"jsonencode":            stdlib.JSONEncodeFunc,
+		"keys":                  stdlib.KeysFunc,
+		"log":                   stdlib.LogFunc,
+		"lower":                 stdlib.LowerFunc,
+		"max":                   stdlib.MaxFunc,
+		"merge":                 stdlib.MergeFunc,
+		"min":                   stdlib.MinFunc,
+		"now":                   NowFunc,
+		"parseint":              stdlib.ParseIntFunc,
+		"parsetime":             ParseTimeFunc,
+		"pow":                   stdlib.PowFunc,
+		"range":                 stdlib.RangeFunc,
+		"regex":                 stdlib.RegexFunc,
+		"regexall":              stdlib.RegexAllFunc,
+		"reverse":               stdlib.ReverseListFunc,
+		"rfc3339":               RFC3339Func,
+		"setintersection":       stdlib.SetIntersectionFunc,
+		"setproduct":            stdlib.SetProductFunc,
+		"setsubtract":           stdlib.SetSubtractFunc,
+		"setunion":              stdlib.SetUnionFunc,
+		"signum":                stdlib.SignumFunc,
+		"strftime":              StrftimeFunc,
+		"strftime_in_zone":      StrftimeInZoneFunc,
+		"strptime":              StrptimeFunc,
+		"slice":                 stdlib.SliceFunc,
+		"sort":                  stdlib.SortFunc,
+		"split":                 stdlib.SplitFunc,
+		"strrev":                stdlib.ReverseFunc,
+		"substr":                stdlib.SubstrFunc,
+		"timeadd":               stdlib.TimeAddFunc,
+		"timecmp":               TimeCmpFunc,
+		"timediff":              TimeDiffFunc,
+		"title":                 stdlib.TitleFunc,
+		"trim":                  stdlib.TrimFunc,
+		"trimprefix":            stdlib.TrimPrefixFunc,
+		"trimspace":             stdlib.TrimSpaceFunc,
+		"trimsuffix":            stdlib.TrimSuffixFunc,
+		"truncate_time":         TruncateTimeFunc,
+		"truncate_time_in_zone": TruncateTimeInZoneFunc,
+		"try":                   tryfunc.TryFunc,
+		"upper":                 stdlib.UpperFunc,
+		"values":                stdlib.ValuesFunc,
+		"yamldecode":            ctyyaml.YAMLDecodeFunc,
+		"yamlencode":            ctyyaml.YAMLEncodeFunc,
+		"zipmap":                stdlib.ZipmapFunc,
+	}
+	f["env"] = makeEnvFunc(opts)
+	f["must_env"] = makeMustEnvFunc(opts)
+	f["file"] = makeFileFunc(opts)
+	r := newTemplateRenderer(f, opts)
+	f["templatefile"] = r.function(nil)
+	f["gotemplatefile"] = r.goFunction(nil)
+	return f
+}
+
+// MustEnvFunc は 指定された環境変数を返すHCLの関数です。環境変数が設定されていない場合はエラーになります。
+// MustEnvFunc is a HCL function that returns the specified environment variable. It returns an error if the variable is not set.
+var MustEnvFunc = makeMustEnvFunc(&utilFunctionOptions{})
+
+// EnvFunc は 指定された環境変数を返すHCLの関数です。環境変数が設定されていない場合はデフォルト値を返します。
+// EnvFunc is a HCL function that returns the specified environment variable, or the default value if the variable is not set.
+var EnvFunc = makeEnvFunc(&utilFunctionOptions{})
+
+// lookupEnv は 許可リストを確認して環境変数を参照し、機密扱いであれば付与すべきマークを返します。
+func lookupEnv(opts *utilFunctionOptions, key string) (string, cty.ValueMarks, error) {
+	if len(opts.envAllowPatterns) > 0 && !matchEnvPatterns(opts.envAllowPatterns, key) {
+		return "", nil, fmt.Errorf("env `%s` is not allowed", key)
+	}
+	var marks cty.ValueMarks
+	if matchEnvPatterns(opts.envSensitivePatterns, key) {
+		marks = cty.NewValueMarks(SensitiveMark)
+	}
+	return os.Getenv(key), marks, nil
+}
+
+func matchEnvPatterns(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, key); err == nil && ok {
+			return true
 		}
-		return cty.StringVal(value).WithMarks(keyMarks), nil
-	},
-})
+	}
+	return false
+}
 
-var EnvFunc = function.New(&function.Spec{
-	Params: []function.Parameter{
-		{
-			Name:        "key",
-			Type:        cty.String,
-			AllowMarked: true,
+func makeMustEnvFunc(opts *utilFunctionOptions) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name:        "key",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
 		},
-		{
-			Name:         "default",
-			Type:         cty.String,
-			AllowNull:    true,
-			AllowUnknown: true,
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			keyArg, keyMarks := args[0].Unmark()
+			key := keyArg.AsString()
+			value, envMarks, err := lookupEnv(opts, key)
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			if value == "" {
+				err := function.NewArgError(0, fmt.Errorf("env `%s` is not set", key))
+				return cty.UnknownVal(cty.String), err
+			}
+			return cty.StringVal(value).WithMarks(keyMarks, envMarks), nil
 		},
-	},
-	Type: function.StaticReturnType(cty.String),
-	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-		keyArg, keyMarks := args[0].Unmark()
-		key := keyArg.AsString()
-		if value := os.Getenv(key); value != "" {
-			return cty.StringVal(value).WithMarks(keyMarks), nil
-		}
-		if args[1].IsNull() {
-			return cty.StringVal("").WithMarks(keyMarks), nil
-		}
-		return cty.StringVal(args[1].AsString()).WithMarks(keyMarks), nil
-	},
-})
+	})
+}
+
+func makeEnvFunc(opts *utilFunctionOptions) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name:        "key",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:         "default",
+				Type:         cty.String,
+				AllowNull:    true,
+				AllowUnknown: true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			keyArg, keyMarks := args[0].Unmark()
+			key := keyArg.AsString()
+			value, envMarks, err := lookupEnv(opts, key)
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			if value != "" {
+				return cty.StringVal(value).WithMarks(keyMarks, envMarks), nil
+			}
+			if args[1].IsNull() {
+				return cty.StringVal("").WithMarks(keyMarks), nil
+			}
+			return cty.StringVal(args[1].AsString()).WithMarks(keyMarks), nil
+		},
+	})
+}
 
 func openFile(path string, baseFSs ...fs.FS) ([]byte, error) {
 	path = filepath.Clean(path)
@@ -215,6 +315,10 @@ func openFile(path string, baseFSs ...fs.FS) ([]byte, error) {
 // text = file("path/to/file")
 // ```
 func MakeFileFunc(baseFSs ...fs.FS) function.Function {
+	return makeFileFunc(&utilFunctionOptions{fsyss: baseFSs})
+}
+
+func makeFileFunc(opts *utilFunctionOptions) function.Function {
 	return function.New(&function.Spec{
 		Params: []function.Parameter{
 			{
@@ -226,77 +330,15 @@ func MakeFileFunc(baseFSs ...fs.FS) function.Function {
 		Type: function.StaticReturnType(cty.String),
 		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
 			pathArg, pathMarks := args[0].Unmark()
-			content, err := openFile(pathArg.AsString(), baseFSs...)
+			content, err := openFile(pathArg.AsString(), opts.fsyss...)
 			if err != nil {
 				err = function.NewArgError(0, err)
 				return cty.UnknownVal(cty.String), err
 			}
-			return cty.StringVal(string(content)).WithMarks(pathMarks), nil
-		},
-	})
-}
-
-// MakeTemplateFileFunc は templatefile 関数を作成して返します。これは、指定されたパスのファイルを読み込んでテンプレートとして処理し、結果を返すHCLの関数です。
-// HCL中での使用例としては以下となります。
-// ```
-// text = templatefile("path/to/file", {key = "value"})
-// ```
-// MakeTemplateFileFunc returns a function that creates the templatefile function. This is a HCL function that reads the file at the specified path, processes it as a template, and returns the result.
-// An example of use in HCL is as follows.
-// ```
-// text = templatefile("path/to/file", {key = "value"})
-// ```
-func MakeTemplateFileFunc(functions map[string]function.Function, baseFSs ...fs.FS) function.Function {
-	render := func(args []cty.Value) (cty.Value, error) {
-		if len(args) != 2 {
-			return cty.UnknownVal(cty.DynamicPseudoType), errors.New("require argument length 2")
-		}
-		if ty := args[1].Type(); !ty.IsObjectType() && !ty.IsMapType() {
-			return cty.UnknownVal(cty.DynamicPseudoType), errors.New("require second argument is map or object type")
-		}
-		pathArg, pathMarks := args[0].Unmark()
-		targetFile := pathArg.AsString()
-		src, err := openFile(targetFile, baseFSs...)
-		if err != nil {
-			err = function.NewArgError(0, err)
-			return cty.UnknownVal(cty.DynamicPseudoType), err
-		}
-		expr, diags := hclsyntax.ParseTemplate(src, targetFile, hcl.InitialPos)
-		if diags.HasErrors() {
-			return cty.UnknownVal(cty.DynamicPseudoType), diags
-		}
-		ctx := &hcl.EvalContext{
-			Variables: args[1].AsValueMap(),
-			Functions: functions,
-		}
-		value, diags := expr.Value(ctx)
-		if diags.HasErrors() {
-			return cty.UnknownVal(cty.DynamicPseudoType), diags
-		}
-		return value.WithMarks(pathMarks), nil
-	}
-
-	return function.New(&function.Spec{
-		Params: []function.Parameter{
-			{
-				Name:        "path",
-				Type:        cty.String,
-				AllowMarked: true,
-			},
-			{
-				Name: "variables",
-				Type: cty.DynamicPseudoType,
-			},
-		},
-		Type: func(args []cty.Value) (cty.Type, error) {
-			if !args[0].IsKnown() || args[1].IsKnown() {
-				return cty.DynamicPseudoType, nil
+			if opts.diagsWriter != nil {
+				opts.diagsWriter.AddSource(pathArg.AsString(), content)
 			}
-			val, err := render(args)
-			return val.Type(), err
-		},
-		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-			return render(args)
+			return cty.StringVal(string(content)).WithMarks(pathMarks), nil
 		},
 	})
 }
@@ -322,12 +364,123 @@ func Strftime(layout string, loc *time.Location, t time.Time) (string, error) {
 		}
 		return f.FormatString(t), nil
 	}
-	if strings.EqualFold("rfc3399", layout) {
+	if isRFC3339Layout(layout) {
 		return t.Format(time.RFC3339), nil
 	}
 	return t.Format(layout), nil
 }
 
+// Strptime は 指定されたレイアウトで文字列を時刻として解釈します。
+// レイアウトは Strftime と同様に、'%' を含む場合は strftime 形式、"rfc3339" の場合は RFC3339、それ以外は Go のレイアウトとして扱います。
+// タイムゾーンを含まない文字列は loc の時刻として解釈されます。
+//
+// Strptime parses value as a time with the specified layout.
+// Like Strftime, a layout containing '%' is a strftime layout, "rfc3339" means RFC3339, and anything else is a Go layout.
+// Values without a time zone are interpreted in loc.
+func Strptime(layout string, value string, loc *time.Location) (time.Time, error) {
+	if strings.ContainsRune(layout, '%') {
+		goLayout, err := strftimeToGoLayout(layout)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.ParseInLocation(goLayout, value, loc)
+	}
+	if isRFC3339Layout(layout) {
+		return time.ParseInLocation(time.RFC3339, value, loc)
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// TruncateTime は 時刻を指定された単位の境界に切り捨てます。
+// unit には second, minute, hour, day, week, month, year もしくは time.ParseDuration で解釈できる文字列を指定します。
+// day 以上の単位は loc のタイムゾーンでの境界になります。week は月曜日始まりです。
+//
+// TruncateTime truncates t to the boundary of the specified unit.
+// unit is one of second, minute, hour, day, week, month, year, or a string accepted by time.ParseDuration.
+// Units of a day or longer use the boundaries in loc. Weeks start on Monday.
+func TruncateTime(t time.Time, unit string, loc *time.Location) (time.Time, error) {
+	t = t.In(loc)
+	switch strings.ToLower(unit) {
+	case "second":
+		return t.Truncate(time.Second), nil
+	case "minute":
+		return t.Truncate(time.Minute), nil
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc), nil
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc), nil
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc), nil
+	case "year":
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, loc), nil
+	}
+	d, err := time.ParseDuration(unit)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unsupported unit `%s`", unit)
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("unit must be positive, got `%s`", unit)
+	}
+	return t.Truncate(d), nil
+}
+
+func isRFC3339Layout(layout string) bool {
+	// rfc3399 is kept for backward compatibility.
+	return strings.EqualFold("rfc3339", layout) || strings.EqualFold("rfc3399", layout)
+}
+
+var strftimeToGoLayoutVerbs = map[byte]string{
+	'A': "Monday",
+	'a': "Mon",
+	'B': "January",
+	'b': "Jan",
+	'D': "01/02/06",
+	'd': "02",
+	'e': "_2",
+	'F': "2006-01-02",
+	'H': "15",
+	'h': "Jan",
+	'I': "03",
+	'j': "002",
+	'M': "04",
+	'm': "01",
+	'p': "PM",
+	'R': "15:04",
+	'r': "03:04:05 PM",
+	'S': "05",
+	'T': "15:04:05",
+	'Y': "2006",
+	'y': "06",
+	'Z': "MST",
+	'z': "-0700",
+	'%': "%",
+}
+
+// strftimeToGoLayout は strftime 形式のレイアウトを Go のレイアウトに変換します。
+func strftimeToGoLayout(layout string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(layout); i++ {
+		c := layout[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(layout) {
+			return "", errors.New("stray % at the end of layout")
+		}
+		i++
+		verb, ok := strftimeToGoLayoutVerbs[layout[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported verb %%%c for parsing", layout[i])
+		}
+		b.WriteString(verb)
+	}
+	return b.String(), nil
+}
+
 func nowUnixSeconds() float64 {
 	now := flextime.Now()
 	return float64(now.Unix())
@@ -335,6 +488,13 @@ func nowUnixSeconds() float64 {
 func unixSecondsToTime(unixSeconds float64) time.Time {
 	return time.Unix(0, int64(unixSeconds*float64(time.Second)))
 }
+func timeToUnixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+func ctyNumberToFloat(v cty.Value) float64 {
+	f, _ := v.AsBigFloat().Float64()
+	return f
+}
 
 // NowFunc は現在時刻を返すHCLの関数です。
 // NowFunc is a HCL function that returns the current time.
@@ -451,3 +611,190 @@ var StrftimeInZoneFunc = function.New(&function.Spec{
 		return cty.StringVal(t).WithMarks(layoutMarks, zoneMarks, unixSeconcsMarks), nil
 	},
 })
+
+// StrptimeFunc は指定されたレイアウトで文字列を解釈して、unix秒に変換するHCLの関数です。
+// StrptimeFunc is a HCL function that parses a string with the specified layout and returns unix seconds.
+var StrptimeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "layout",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+		{
+			Name:        "str",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		layoutArg, layoutMarks := args[0].Unmark()
+		strArg, strMarks := args[1].Unmark()
+		t, err := Strptime(layoutArg.AsString(), strArg.AsString(), time.Local)
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
+		}
+		return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(layoutMarks, strMarks), nil
+	},
+})
+
+// ParseTimeFunc はRFC3339形式の文字列を解釈して、unix秒に変換するHCLの関数です。
+// ParseTimeFunc is a HCL function that parses a RFC3339 string and returns unix seconds.
+var ParseTimeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "str",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		strArg, strMarks := args[0].Unmark()
+		t, err := time.Parse(time.RFC3339, strArg.AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(0, err)
+		}
+		return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(strMarks), nil
+	},
+})
+
+// RFC3339Func はunix秒をRFC3339形式(UTC)の文字列に変換するHCLの関数です。
+// RFC3339Func is a HCL function that formats unix seconds as a RFC3339 string in UTC.
+var RFC3339Func = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "unixSeconds",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		unixSecondsArg, unixSecondsMarks := args[0].Unmark()
+		t := unixSecondsToTime(ctyNumberToFloat(unixSecondsArg)).UTC()
+		return cty.StringVal(t.Format(time.RFC3339Nano)).WithMarks(unixSecondsMarks), nil
+	},
+})
+
+// TimeDiffFunc は2つのunix秒の差(a - b)を秒数で返すHCLの関数です。
+// TimeDiffFunc is a HCL function that returns the difference (a - b) of two unix seconds in seconds.
+var TimeDiffFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "a",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+		{
+			Name:        "b",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		aArg, aMarks := args[0].Unmark()
+		bArg, bMarks := args[1].Unmark()
+		a := unixSecondsToTime(ctyNumberToFloat(aArg))
+		b := unixSecondsToTime(ctyNumberToFloat(bArg))
+		return cty.NumberFloatVal(a.Sub(b).Seconds()).WithMarks(aMarks, bMarks), nil
+	},
+})
+
+// TimeCmpFunc は2つのunix秒を比較して、a が b より前なら -1、同じなら 0、後なら 1 を返すHCLの関数です。
+// TimeCmpFunc is a HCL function that compares two unix seconds and returns -1 if a is before b, 0 if they are equal, and 1 if a is after b.
+var TimeCmpFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "a",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+		{
+			Name:        "b",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		aArg, aMarks := args[0].Unmark()
+		bArg, bMarks := args[1].Unmark()
+		a := unixSecondsToTime(ctyNumberToFloat(aArg))
+		b := unixSecondsToTime(ctyNumberToFloat(bArg))
+		var ret int64
+		switch {
+		case a.Before(b):
+			ret = -1
+		case a.After(b):
+			ret = 1
+		}
+		return cty.NumberIntVal(ret).WithMarks(aMarks, bMarks), nil
+	},
+})
+
+// TruncateTimeFunc はunix秒を指定された単位の境界に切り捨てるHCLの関数です。
+// TruncateTimeFunc is a HCL function that truncates unix seconds to the boundary of the specified unit.
+var TruncateTimeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "unixSeconds",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+		{
+			Name:        "unit",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		unixSecondsArg, unixSecondsMarks := args[0].Unmark()
+		unitArg, unitMarks := args[1].Unmark()
+		t, err := TruncateTime(unixSecondsToTime(ctyNumberToFloat(unixSecondsArg)), unitArg.AsString(), time.Local)
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
+		}
+		return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(unixSecondsMarks, unitMarks), nil
+	},
+})
+
+// TruncateTimeInZoneFunc は指定されたタイムゾーンでunix秒を指定された単位の境界に切り捨てるHCLの関数です。
+// TruncateTimeInZoneFunc is a HCL function that truncates unix seconds to the boundary of the specified unit in the specified time zone.
+var TruncateTimeInZoneFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name:        "unixSeconds",
+			Type:        cty.Number,
+			AllowMarked: true,
+		},
+		{
+			Name:        "unit",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+		{
+			Name:        "timeZone",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		unixSecondsArg, unixSecondsMarks := args[0].Unmark()
+		unitArg, unitMarks := args[1].Unmark()
+		zoneArg, zoneMarks := args[2].Unmark()
+		loc, err := time.LoadLocation(zoneArg.AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(2, err)
+		}
+		t, err := TruncateTime(unixSecondsToTime(ctyNumberToFloat(unixSecondsArg)), unitArg.AsString(), loc)
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
+		}
+		return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(unixSecondsMarks, unitMarks, zoneMarks), nil
+	},
+})
@@ -1,6 +1,7 @@
 package hclutil_test
 
 import (
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -69,3 +70,162 @@ func TestHCLFunctionCoalesce__MarshalNull(t *testing.T) {
 		t.Errorf("want %d, got %d", 1704067200, ret)
 	}
 }
+
+func TestHCLFunctionEnv__AllowedEnv(t *testing.T) {
+	t.Setenv("APP_NAME", "hoge")
+	t.Setenv("OTHER_NAME", "fuga")
+	ctx := hclutil.NewEvalContext(hclutil.WithAllowedEnv("APP_*"))
+
+	expr, diags := hclsyntax.ParseExpression([]byte(`env("APP_NAME", "")`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	var str string
+	diags = gohcl.DecodeExpression(expr, ctx, &str)
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("decode failed")
+	}
+	if str != "hoge" {
+		t.Errorf("want %q, got %q", "hoge", str)
+	}
+
+	for _, src := range []string{`env("OTHER_NAME", "")`, `must_env("OTHER_NAME")`} {
+		expr, diags = hclsyntax.ParseExpression([]byte(src), "", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			t.Log(diags)
+			t.Fatal("parse failed")
+		}
+		_, diags = expr.Value(ctx)
+		if !diags.HasErrors() {
+			t.Errorf("%s: expected error, got none", src)
+			continue
+		}
+		if !strings.Contains(diags.Error(), "env `OTHER_NAME` is not allowed") {
+			t.Errorf("%s: unexpected error: %s", src, diags.Error())
+		}
+	}
+}
+
+func TestHCLFunctionEnv__SensitiveEnv(t *testing.T) {
+	t.Setenv("APP_NAME", "hoge")
+	t.Setenv("APP_TOKEN", "secret")
+	ctx := hclutil.NewEvalContext(hclutil.WithSensitiveEnv("*_TOKEN", "*_SECRET"))
+
+	expr, diags := hclsyntax.ParseExpression([]byte(`{ name = env("APP_NAME", ""), token = must_env("APP_TOKEN") }`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("eval failed")
+	}
+	if value.GetAttr("name").HasMark(hclutil.SensitiveMark) {
+		t.Error("name should not be marked as sensitive")
+	}
+	if !value.GetAttr("token").HasMark(hclutil.SensitiveMark) {
+		t.Error("token should be marked as sensitive")
+	}
+	dumped := hclutil.MustDumpCtyValue(value)
+	if want := `{"name":"hoge","token":"(sensitive value)"}`; dumped != want {
+		t.Errorf("want %s, got %s", want, dumped)
+	}
+}
+
+func TestHCLFunctionTime(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		expr string
+		want cty.Value
+	}{
+		{
+			expr: `strptime("%Y-%m-%dT%H:%M:%S%z", "2024-01-02T03:04:05+0000")`,
+			want: cty.NumberIntVal(1704164645),
+		},
+		{
+			expr: `strptime("rfc3339", "2024-01-02T12:04:05+09:00")`,
+			want: cty.NumberIntVal(1704164645),
+		},
+		{
+			expr: `parsetime("2024-01-02T03:04:05Z")`,
+			want: cty.NumberIntVal(1704164645),
+		},
+		{
+			expr: `rfc3339(1704164645)`,
+			want: cty.StringVal("2024-01-02T03:04:05Z"),
+		},
+		{
+			expr: `timediff(parsetime("2024-01-02T00:00:00Z"), parsetime("2024-01-01T00:00:00Z"))`,
+			want: cty.NumberIntVal(86400),
+		},
+		{
+			expr: `timecmp(parsetime("2024-01-01T00:00:00Z"), parsetime("2024-01-02T00:00:00Z"))`,
+			want: cty.NumberIntVal(-1),
+		},
+		{
+			expr: `timecmp(1704164645, 1704164645)`,
+			want: cty.NumberIntVal(0),
+		},
+		{
+			expr: `rfc3339(truncate_time_in_zone(parsetime("2024-01-03T15:04:05Z"), "day", "UTC"))`,
+			want: cty.StringVal("2024-01-03T00:00:00Z"),
+		},
+		{
+			expr: `rfc3339(truncate_time_in_zone(parsetime("2024-01-03T15:04:05Z"), "day", "Asia/Tokyo"))`,
+			want: cty.StringVal("2024-01-03T15:00:00Z"),
+		},
+		{
+			expr: `rfc3339(truncate_time_in_zone(parsetime("2024-01-03T15:04:05Z"), "week", "UTC"))`,
+			want: cty.StringVal("2024-01-01T00:00:00Z"),
+		},
+		{
+			expr: `rfc3339(truncate_time_in_zone(parsetime("2024-03-03T15:04:05Z"), "month", "UTC"))`,
+			want: cty.StringVal("2024-03-01T00:00:00Z"),
+		},
+		{
+			expr: `rfc3339(truncate_time_in_zone(parsetime("2024-03-03T15:04:05Z"), "15m", "UTC"))`,
+			want: cty.StringVal("2024-03-03T15:00:00Z"),
+		},
+		{
+			expr: `strftime_in_zone("%Y/%m/%d", "UTC", strptime("%Y/%m/%d %z", "2024/01/02 +0000"))`,
+			want: cty.StringVal("2024/01/02"),
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "", hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				t.Log(diags)
+				t.Fatal("parse failed")
+			}
+			got, diags := expr.Value(hclutil.NewEvalContext())
+			if diags.HasErrors() {
+				t.Log(diags)
+				t.Fatal("eval failed")
+			}
+			if !got.Equals(c.want).True() {
+				t.Errorf("want %s, got %s", c.want.GoString(), got.GoString())
+			}
+		})
+	}
+}
+
+func TestHCLFunctionStrptime__UnsupportedVerb(t *testing.T) {
+	t.Parallel()
+	expr, diags := hclsyntax.ParseExpression([]byte(`strptime("%Q", "2024")`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	_, diags = expr.Value(hclutil.NewEvalContext())
+	if !diags.HasErrors() {
+		t.Fatal("expected error, got none")
+	}
+	if !strings.Contains(diags.Error(), "unsupported verb %Q for parsing") {
+		t.Errorf("unexpected error: %s", diags.Error())
+	}
+}
@@ -0,0 +1,347 @@
+package hclutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// BlockGraph は ブロック間の参照から作った依存関係のグラフです。
+// ブロックは BlockAddress のアドレスで識別され、`service "api" {}` が `database.main.url` を参照すると service.api は database.main に依存します。
+//
+// BlockGraph is a dependency graph built from references between blocks.
+// Blocks are identified by BlockAddress, and when `service "api" {}` refers to `database.main.url`, service.api depends on database.main.
+type BlockGraph struct {
+	blocks map[string]*hcl.Block
+	deps   map[string][]string
+	order  []string
+	levels [][]string
+}
+
+// NewBlockGraph は schema に含まれるブロックから BlockGraph を作ります。
+// 参照は BodyReferences と同じくネストしたブロックも含めて集められます。ブロック以外への参照は無視されます。
+// 循環した参照は、循環の経路を示すエラーとして報告され、循環に含まれるブロックとそれに依存するブロックは評価されません。
+//
+// NewBlockGraph builds a BlockGraph from the blocks in schema.
+// References are collected including nested blocks, like BodyReferences. References to anything other than blocks are ignored.
+// Reference cycles are reported as errors naming the chain, and blocks in a cycle and blocks depending on them are not evaluated.
+func NewBlockGraph(body hcl.Body, schema *hcl.BodySchema) (*BlockGraph, hcl.Diagnostics) {
+	content, _, diags := body.PartialContent(schema)
+	g := &BlockGraph{
+		blocks: make(map[string]*hcl.Block, len(content.Blocks)),
+		deps:   make(map[string][]string, len(content.Blocks)),
+	}
+	refs := make(map[string][]Reference, len(content.Blocks))
+	for _, block := range content.Blocks {
+		addr := BlockAddress(block)
+		if other, ok := g.blocks[addr]; ok {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf(`Duplicate "%s" block`, addr),
+				Detail:   fmt.Sprintf(`Only one "%s" block is allowed. Another was defined at %s`, addr, other.DefRange.String()),
+				Subject:  block.DefRange.Ptr(),
+			})
+			continue
+		}
+		g.blocks[addr] = block
+		r, d := bodyReferences(block.Body)
+		diags = diags.Extend(d)
+		refs[addr] = r
+	}
+	for addr, r := range refs {
+		seen := make(map[string]bool)
+		for _, ref := range r {
+			dep, ok := g.referencedBlock(ref.Traversal)
+			if !ok || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			g.deps[addr] = append(g.deps[addr], dep)
+		}
+		sort.Strings(g.deps[addr])
+	}
+	diags = diags.Extend(g.checkCycles())
+	g.sort()
+	return g, diags
+}
+
+// referencedBlock は traversal の先頭から最も長く一致するブロックのアドレスを返します。
+func (g *BlockGraph) referencedBlock(traversal hcl.Traversal) (string, bool) {
+	var found string
+	addr := traversal.RootName()
+	for i := 0; ; i++ {
+		if _, ok := g.blocks[addr]; ok {
+			found = addr
+		}
+		if i+1 >= len(traversal) {
+			break
+		}
+		attr, ok := traversal[i+1].(hcl.TraverseAttr)
+		if !ok {
+			break
+		}
+		addr += "." + attr.Name
+	}
+	return found, found != ""
+}
+
+// checkCycles は 循環した参照を見つけて、その経路をエラーとして返します。
+func (g *BlockGraph) checkCycles() hcl.Diagnostics {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var diags hcl.Diagnostics
+	state := make(map[string]int, len(g.blocks))
+	var stack []string
+	var visit func(addr string)
+	visit = func(addr string) {
+		state[addr] = visiting
+		stack = append(stack, addr)
+		for _, dep := range g.deps[addr] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				var start int
+				for i, a := range stack {
+					if a == dep {
+						start = i
+					}
+				}
+				chain := append(append([]string{}, stack[start:]...), dep)
+				diags = diags.Append(&hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Dependency cycle",
+					Detail:   fmt.Sprintf("The blocks refer to each other in a cycle: %s.", strings.Join(chain, " -> ")),
+					Subject:  g.blocks[dep].DefRange.Ptr(),
+				})
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[addr] = visited
+	}
+	for _, addr := range sortedKeys(g.blocks) {
+		if state[addr] == unvisited {
+			visit(addr)
+		}
+	}
+	return diags
+}
+
+// sort は 依存関係の深さごとにブロックを並べます。循環に含まれるブロックとそれに依存するブロックは含まれません。
+func (g *BlockGraph) sort() {
+	level := make(map[string]int, len(g.blocks))
+	remaining := sortedKeys(g.blocks)
+	for progress := true; progress && len(remaining) > 0; {
+		progress = false
+		var next, current []string
+		for _, addr := range remaining {
+			ready := true
+			for _, dep := range g.deps[addr] {
+				if _, ok := level[dep]; !ok {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				current = append(current, addr)
+			} else {
+				next = append(next, addr)
+			}
+		}
+		if len(current) > 0 {
+			for _, addr := range current {
+				level[addr] = len(g.levels)
+			}
+			g.levels = append(g.levels, current)
+			g.order = append(g.order, current...)
+			progress = true
+		}
+		remaining = next
+	}
+}
+
+// Block は アドレスのブロックを返します。
+// Block returns the block at the address.
+func (g *BlockGraph) Block(addr string) (*hcl.Block, bool) {
+	block, ok := g.blocks[addr]
+	return block, ok
+}
+
+// Dependencies は アドレスのブロックが直接参照しているブロックのアドレスを返します。
+// Dependencies returns the addresses of the blocks that the block at the address refers to directly.
+func (g *BlockGraph) Dependencies(addr string) []string {
+	return append([]string(nil), g.deps[addr]...)
+}
+
+// Order は 評価できるブロックのアドレスを依存関係の順に返します。同じ深さのブロックはアドレス順です。
+// Order returns the addresses of evaluable blocks in dependency order. Blocks at the same depth are ordered by address.
+func (g *BlockGraph) Order() []string {
+	return append([]string(nil), g.order...)
+}
+
+type blockGraphOptions struct {
+	concurrency int
+	evaluator   func(block *hcl.Block, evalCtx *hcl.EvalContext) (cty.Value, hcl.Diagnostics)
+}
+
+// WithBlockGraphConcurrency は 互いに依存しないブロックを並行して評価する数の上限を指定します。デフォルトは 1 で、順に評価します。
+// WithBlockGraphConcurrency sets the maximum number of independent blocks evaluated concurrently. The default is 1, evaluating sequentially.
+func WithBlockGraphConcurrency(n int) func(*blockGraphOptions) {
+	return func(opts *blockGraphOptions) {
+		if n < 1 {
+			n = 1
+		}
+		opts.concurrency = n
+	}
+}
+
+// WithBlockEvaluator は ブロックを評価する関数を指定します。
+// デフォルトではブロックの属性だけを評価してオブジェクトにします。ネストしたブロックも必要な場合は、gohcl.DecodeBody と MarshalCTYValue などを使った関数を指定します。
+//
+// WithBlockEvaluator sets the function that evaluates a block.
+// By default only the attributes of the block are evaluated into an object.
+// When nested blocks are needed, set a function using gohcl.DecodeBody and MarshalCTYValue, for example.
+func WithBlockEvaluator(fn func(block *hcl.Block, evalCtx *hcl.EvalContext) (cty.Value, hcl.Diagnostics)) func(*blockGraphOptions) {
+	return func(opts *blockGraphOptions) {
+		opts.evaluator = fn
+	}
+}
+
+// Evaluate は ブロックを依存関係の順に評価し、その値を `<type>.<label>` の変数として追加したEvalContextを返します。
+// 各ブロックは、それまでに評価されたブロックの変数を含むEvalContextで評価されます。
+// 評価でエラーになったブロックに依存するブロックと、循環に含まれるかそれに依存するブロックは評価されず、ブロックごとにエラーが返されます。
+//
+// Evaluate evaluates blocks in dependency order and returns an EvalContext with their values added as `<type>.<label>` variables.
+// Each block is evaluated with an EvalContext containing the variables of the blocks evaluated before it.
+// Blocks depending on a block that failed to evaluate, and blocks in or depending on a cycle, are not evaluated,
+// and an error is returned for each of them.
+//
+//	g, diags := hclutil.NewBlockGraph(body, schema)
+//	evalCtx, diags = g.Evaluate(evalCtx, hclutil.WithBlockGraphConcurrency(4))
+//	url, _ := hclutil.GetValue(evalCtx, "service.api.url")
+func (g *BlockGraph) Evaluate(ctx *hcl.EvalContext, optFns ...func(*blockGraphOptions)) (*hcl.EvalContext, hcl.Diagnostics) {
+	opts := &blockGraphOptions{
+		concurrency: 1,
+		evaluator:   evaluateBlockAttributes,
+	}
+	for _, optFn := range optFns {
+		optFn(opts)
+	}
+	var diags hcl.Diagnostics
+	evaluable := make(map[string]bool, len(g.order))
+	for _, addr := range g.order {
+		evaluable[addr] = true
+	}
+	for _, addr := range sortedKeys(g.blocks) {
+		if evaluable[addr] {
+			continue
+		}
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Block not evaluated",
+			Detail:   fmt.Sprintf("The block %q was not evaluated because it is part of or depends on a dependency cycle.", addr),
+			Subject:  g.blocks[addr].DefRange.Ptr(),
+		})
+	}
+	variables := make(map[string]cty.Value)
+	failed := make(map[string]bool)
+	evalCtx := ctx
+	for _, level := range g.levels {
+		var targets []string
+		for _, addr := range level {
+			if dep, ok := g.failedDependency(addr, failed); ok {
+				failed[addr] = true
+				diags = diags.Append(&hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Block not evaluated",
+					Detail:   fmt.Sprintf("The block %q was not evaluated because the block %q it depends on failed.", addr, dep),
+					Subject:  g.blocks[addr].DefRange.Ptr(),
+				})
+				continue
+			}
+			targets = append(targets, addr)
+		}
+		values := make([]cty.Value, len(targets))
+		valueDiags := make([]hcl.Diagnostics, len(targets))
+		jobs := make(chan int)
+		workers := opts.concurrency
+		if len(targets) < workers {
+			workers = len(targets)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					values[j], valueDiags[j] = opts.evaluator(g.blocks[targets[j]], evalCtx)
+				}
+			}()
+		}
+		for j := range targets {
+			jobs <- j
+		}
+		close(jobs)
+		wg.Wait()
+
+		for j, addr := range targets {
+			diags = diags.Extend(valueDiags[j])
+			if valueDiags[j].HasErrors() {
+				failed[addr] = true
+				continue
+			}
+			block := g.blocks[addr]
+			traversal := hcl.Traversal{hcl.TraverseRoot{Name: block.Type, SrcRange: block.TypeRange}}
+			for i, label := range block.Labels {
+				traversal = append(traversal, hcl.TraverseAttr{Name: label, SrcRange: block.LabelRanges[i]})
+			}
+			current, ok := variables[block.Type]
+			if !ok {
+				current = cty.NilVal
+			}
+			v, d := setValuePath(current, traversal, 1, values[j])
+			diags = diags.Extend(d)
+			if d.HasErrors() {
+				failed[addr] = true
+				continue
+			}
+			variables[block.Type] = v
+		}
+		if len(variables) > 0 {
+			evalCtx = WithVariables(ctx, variables)
+		}
+	}
+	return evalCtx, diags
+}
+
+// failedDependency は アドレスのブロックが依存しているブロックのうち、失敗した最初のものを返します。
+func (g *BlockGraph) failedDependency(addr string, failed map[string]bool) (string, bool) {
+	for _, dep := range g.deps[addr] {
+		if failed[dep] {
+			return dep, true
+		}
+	}
+	return "", false
+}
+
+// evaluateBlockAttributes は ブロックの属性を評価してオブジェクトにします。
+func evaluateBlockAttributes(block *hcl.Block, evalCtx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	attrs, diags := ExtructAttributes(block.Body)
+	if diags.HasErrors() {
+		return cty.NilVal, diags
+	}
+	values := make(map[string]cty.Value, len(attrs))
+	for name, attr := range attrs {
+		v, d := attr.Expr.Value(evalCtx)
+		diags = diags.Extend(d)
+		values[name] = v
+	}
+	return cty.ObjectVal(values), diags
+}
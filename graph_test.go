@@ -0,0 +1,168 @@
+package hclutil_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var graphSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "database", LabelNames: []string{"name"}},
+		{Type: "service", LabelNames: []string{"name"}},
+		{Type: "settings"},
+	},
+}
+
+func TestBlockGraph(t *testing.T) {
+	t.Parallel()
+	src := `
+service "api" {
+  url = "${database.main.url}/api"
+  env = settings.env
+}
+
+service "web" {
+  upstream = service.api.url
+}
+
+database "main" {
+  url = "postgres://${var.host}/${settings.env}"
+}
+
+settings {
+  env = "prod"
+}
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	g, diags := hclutil.NewBlockGraph(file.Body, graphSchema)
+	diagsReport(t, diags)
+	require.Equal(t, []string{"settings", "database.main", "service.api", "service.web"}, g.Order())
+	require.Equal(t, []string{"database.main", "settings"}, g.Dependencies("service.api"))
+
+	for _, concurrency := range []int{1, 4} {
+		ctx := hclutil.WithValue(hclutil.NewEvalContext(), "var.host", cty.StringVal("db.local"))
+		ctx, diags = g.Evaluate(ctx, hclutil.WithBlockGraphConcurrency(concurrency))
+		diagsReport(t, diags)
+		v, diags := hclutil.GetValue(ctx, "service.web.upstream")
+		diagsReport(t, diags)
+		require.Equal(t, cty.StringVal("postgres://db.local/prod/api"), v)
+		v, diags = hclutil.GetValue(ctx, "var.host")
+		diagsReport(t, diags)
+		require.Equal(t, cty.StringVal("db.local"), v)
+	}
+}
+
+func TestBlockGraph__Cycle(t *testing.T) {
+	t.Parallel()
+	src := `
+service "api" {
+  url = service.web.url
+}
+
+service "web" {
+  url = database.main.url
+}
+
+database "main" {
+  url = service.api.url
+}
+
+settings {
+  env = "prod"
+}
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	g, diags := hclutil.NewBlockGraph(file.Body, graphSchema)
+	require.Len(t, diags, 1)
+	require.Equal(t, "Dependency cycle", diags[0].Summary)
+	require.Equal(t, "The blocks refer to each other in a cycle: database.main -> service.api -> service.web -> database.main.", diags[0].Detail)
+	require.Equal(t, []string{"settings"}, g.Order())
+
+	ctx, diags := g.Evaluate(hclutil.NewEvalContext())
+	require.Len(t, diags, 3)
+	for i, addr := range []string{"database.main", "service.api", "service.web"} {
+		require.Equal(t, "Block not evaluated", diags[i].Summary)
+		require.Equal(t, `The block "`+addr+`" was not evaluated because it is part of or depends on a dependency cycle.`, diags[i].Detail)
+	}
+	v, diags := hclutil.GetValue(ctx, "settings.env")
+	diagsReport(t, diags)
+	require.Equal(t, cty.StringVal("prod"), v)
+}
+
+func TestBlockGraph__EvaluateError(t *testing.T) {
+	t.Parallel()
+	src := `
+database "main" {
+  url = var.undefined
+}
+
+service "api" {
+  url = database.main.url
+}
+
+settings {
+  env = "prod"
+}
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	g, diags := hclutil.NewBlockGraph(file.Body, graphSchema)
+	diagsReport(t, diags)
+
+	var evaluated []string
+	ctx, diags := g.Evaluate(hclutil.NewEvalContext(), hclutil.WithBlockEvaluator(func(block *hcl.Block, evalCtx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+		evaluated = append(evaluated, hclutil.BlockAddress(block))
+		attrs, diags := block.Body.JustAttributes()
+		values := make(map[string]cty.Value, len(attrs))
+		for name, attr := range attrs {
+			v, d := attr.Expr.Value(evalCtx)
+			diags = diags.Extend(d)
+			values[name] = v
+		}
+		return cty.ObjectVal(values), diags
+	}))
+	require.Len(t, diags, 2)
+	require.Equal(t, 3, diags[0].Subject.Start.Line)
+	require.Equal(t, "Block not evaluated", diags[1].Summary)
+	require.Equal(t, `The block "service.api" was not evaluated because the block "database.main" it depends on failed.`, diags[1].Detail)
+	require.Equal(t, 6, diags[1].Subject.Start.Line)
+	require.Equal(t, []string{"database.main", "settings"}, evaluated)
+	_, diags = hclutil.GetValue(ctx, "service.api")
+	require.True(t, diags.HasErrors())
+}
+
+func TestBlockGraph__Override(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+service "api" {
+  url = "http://localhost"
+}
+
+database "main" {
+  url = "postgres://db.local"
+}
+`)},
+		"main_override.hcl": {Data: []byte(`
+service "api" {
+  health {
+    path = database.main.url
+  }
+}
+`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	g, diags := hclutil.NewBlockGraph(body, graphSchema)
+	diagsReport(t, diags)
+	require.Equal(t, []string{"database.main"}, g.Dependencies("service.api"))
+	require.Equal(t, []string{"database.main", "service.api"}, g.Order())
+}
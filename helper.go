@@ -6,12 +6,49 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+// ValueMark は hclutil が cty.Value に付与するマークの型です。
+// ValueMark is the type of marks that hclutil applies to cty.Value.
+type ValueMark string
+
+// SensitiveMark は 機密情報であることを示すマークです。
+// DumpCTYValue はこのマークが付与された値を出力しません。
+//
+// SensitiveMark marks a value as sensitive.
+// DumpCTYValue redacts values carrying this mark.
+const SensitiveMark ValueMark = "sensitive"
+
+const redactedValue = "(sensitive value)"
+
+// RedactSensitive は SensitiveMark が付与された値を伏せ字に置き換えます。
+// 文字列は "(sensitive value)" に、それ以外の型は同じ型の null に置き換えられます。
+//
+// RedactSensitive replaces values marked with SensitiveMark.
+// Strings become "(sensitive value)", other types become null of the same type.
+func RedactSensitive(v cty.Value) cty.Value {
+	redacted, err := cty.Transform(v, func(_ cty.Path, v cty.Value) (cty.Value, error) {
+		if !v.HasMark(SensitiveMark) {
+			return v, nil
+		}
+		_, marks := v.Unmark()
+		if v.Type() == cty.String {
+			return cty.StringVal(redactedValue).WithMarks(marks), nil
+		}
+		return cty.NullVal(v.Type()).WithMarks(marks), nil
+	})
+	if err != nil {
+		return v
+	}
+	return redacted
+}
+
 // DumpCTYValue は cty.Value をJSON文字列に変換します。
 //
 //	これは、ログ出力等を行うときのデバッグ用途を想定しています。
+//	SensitiveMark が付与された値は伏せ字になります。
 func DumpCTYValue(v cty.Value) (string, error) {
+	redacted, _ := RedactSensitive(v).UnmarkDeep()
 	var raw json.RawMessage
-	if err := UnmarshalCTYValue(v, &raw); err != nil {
+	if err := UnmarshalCTYValue(redacted, &raw); err != nil {
 		return "", err
 	}
 	return string(raw), nil
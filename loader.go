@@ -0,0 +1,190 @@
+package hclutil
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+)
+
+type loaderOptions struct {
+	path        string
+	fsys        fs.FS
+	parseOptFns []func(*parseOptions)
+	funcOptFns  []func(*utilFunctionOptions)
+	variables   map[string]cty.Value
+	strict      bool
+	output      io.Writer
+	format      DiagnosticsFormat
+}
+
+// WithLoaderPath は 読み込むファイルまたはディレクトリのパスを指定します。デフォルトはカレントディレクトリです。
+// WithLoaderPath sets the path of the file or directory to load. The default is the current directory.
+func WithLoaderPath(p string) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.path = p
+		opts.fsys = nil
+	}
+}
+
+// WithLoaderFS は 読み込む fs.FS を指定します。指定した場合はパスの代わりに ParseFS で読み込みます。
+// WithLoaderFS sets the fs.FS to load. When set, it is loaded with ParseFS instead of the path.
+func WithLoaderFS(fsys fs.FS) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.fsys = fsys
+	}
+}
+
+// WithLoaderParseOptions は 解析に使うオプション(WithFileExtension など)を指定します。
+// WithLoaderParseOptions sets the options used for parsing, such as WithFileExtension.
+func WithLoaderParseOptions(optFns ...func(*parseOptions)) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.parseOptFns = append(opts.parseOptFns, optFns...)
+	}
+}
+
+// WithLoaderFunctionOptions は NewEvalContext に渡すオプションを指定します。
+// WithLoaderFunctionOptions sets the options passed to NewEvalContext.
+func WithLoaderFunctionOptions(optFns ...func(*utilFunctionOptions)) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.funcOptFns = append(opts.funcOptFns, optFns...)
+	}
+}
+
+// WithLoaderVariables は EvalContext に追加する外部の変数を指定します。複数回指定するとマージされます。
+// WithLoaderVariables sets external variables added to the EvalContext. Multiple calls are merged.
+func WithLoaderVariables(variables map[string]cty.Value) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.variables = MergeVariables(opts.variables, variables)
+	}
+}
+
+// WithLoaderStrict は 警告をエラーとして扱うかどうかを指定します。読み飛ばしたファイルの警告はエラーになりません。
+// WithLoaderStrict sets whether warnings are treated as errors. Warnings about skipped files are not.
+func WithLoaderStrict(strict bool) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.strict = strict
+	}
+}
+
+// WithLoaderDiagnosticsOutput は 診断情報の出力先を指定します。デフォルトは標準エラー出力です。
+// WithLoaderDiagnosticsOutput sets the output of diagnostics. The default is stderr.
+func WithLoaderDiagnosticsOutput(w io.Writer) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.output = w
+	}
+}
+
+// WithLoaderDiagnosticsFormat は 診断情報の出力形式を指定します。
+// WithLoaderDiagnosticsFormat sets the format of diagnostics.
+func WithLoaderDiagnosticsFormat(format DiagnosticsFormat) func(*loaderOptions) {
+	return func(opts *loaderOptions) {
+		opts.format = format
+	}
+}
+
+// Loader は Parse, NewEvalContext, DecodeLocals, gohcl.DecodeBody をまとめて実行します。
+// 解析結果は ParseCache に記憶されるため、同じ Loader で繰り返し Load すると変更されたファイルだけが再解析されます。
+//
+// Loader runs Parse, NewEvalContext, DecodeLocals and gohcl.DecodeBody together.
+// Parsed files are remembered in a ParseCache, so loading repeatedly with the same Loader re-parses only changed files.
+type Loader struct {
+	opts  *loaderOptions
+	cache *ParseCache
+
+	mu     sync.Mutex
+	writer *DiagnosticsWriter
+}
+
+// NewLoader は 新しい Loader を返します。
+// NewLoader returns a new Loader.
+//
+//	loader := hclutil.NewLoader(
+//		hclutil.WithLoaderPath("./config"),
+//		hclutil.WithLoaderVariables(map[string]cty.Value{"var": vars}),
+//	)
+//	var cfg Config
+//	if err := loader.Load(ctx, &cfg); err != nil {
+//		log.Fatal(err)
+//	}
+func NewLoader(optFns ...func(*loaderOptions)) *Loader {
+	opts := &loaderOptions{
+		path: ".",
+	}
+	for _, optFn := range optFns {
+		optFn(opts)
+	}
+	return &Loader{
+		opts:  opts,
+		cache: NewParseCache(opts.parseOptFns...),
+	}
+}
+
+// Load は 設定を読み込んで v にデコードします。
+// 診断情報は DiagnosticsWriter で出力され、エラーがある場合は *DiagnosticsError を返します。
+//
+// Load loads the configuration and decodes it into v.
+// Diagnostics are written with the DiagnosticsWriter, and *DiagnosticsError is returned when there are errors.
+func (l *Loader) Load(ctx context.Context, v interface{}) error {
+	body, writer, diags := l.parse()
+	l.mu.Lock()
+	l.writer = writer
+	l.mu.Unlock()
+	if l.opts.output != nil {
+		writer.SetOutput(l.opts.output)
+	}
+	writer.SetFormat(l.opts.format)
+	writer.SetWarningsAsErrors(l.opts.strict)
+	if diags.HasErrors() {
+		return writeLoaderDiagnostics(writer, diags)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	funcOptFns := append([]func(*utilFunctionOptions){WithDiagnosticsWriter(writer)}, l.opts.funcOptFns...)
+	// 変数がなくても Variables を空でないマップにして、"Variables not allowed" ではなく "Unknown variable" を報告させる
+	evalCtx := WithVariables(NewEvalContext(funcOptFns...), l.opts.variables)
+	body, evalCtx, d := DecodeLocals(body, evalCtx)
+	diags = append(diags, d...)
+	if diags.HasErrors() {
+		return writeLoaderDiagnostics(writer, diags)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	diags = append(diags, gohcl.DecodeBody(body, evalCtx, v)...)
+	if len(diags) == 0 {
+		return nil
+	}
+	return writeLoaderDiagnostics(writer, diags)
+}
+
+// writeLoaderDiagnostics は 診断情報を出力し、SARIF 形式のように貯めておく形式の出力もすぐに書き出します。
+func writeLoaderDiagnostics(writer *DiagnosticsWriter, diags hcl.Diagnostics) error {
+	err := writer.WriteDiagnostics(diags)
+	if flushErr := writer.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+// DiagnosticsWriter は 最後の Load で使った DiagnosticsWriter を返します。Load の前は nil です。
+// DiagnosticsWriter returns the DiagnosticsWriter used by the last Load. It is nil before Load.
+func (l *Loader) DiagnosticsWriter() *DiagnosticsWriter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writer
+}
+
+func (l *Loader) parse() (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	if l.opts.fsys != nil {
+		return l.cache.ParseFS(l.opts.fsys)
+	}
+	return l.cache.Parse(l.opts.path)
+}
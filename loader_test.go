@@ -0,0 +1,127 @@
+package hclutil_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+type loaderTestConfig struct {
+	App struct {
+		Name   string `hcl:"name"`
+		Region string `hcl:"region"`
+	} `hcl:"app,block"`
+}
+
+func TestLoader(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+locals {
+  name = "my-${var.env}-app"
+}
+app {
+  name   = local.name
+  region = var.region
+}
+`)},
+	}
+	var buf bytes.Buffer
+	loader := hclutil.NewLoader(
+		hclutil.WithLoaderFS(testFs),
+		hclutil.WithLoaderVariables(map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("prod")}),
+		}),
+		hclutil.WithLoaderVariables(map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{"region": cty.StringVal("ap-northeast-1")}),
+		}),
+		hclutil.WithLoaderDiagnosticsOutput(&buf),
+	)
+	var cfg loaderTestConfig
+	require.NoError(t, loader.Load(context.Background(), &cfg))
+	require.Equal(t, "my-prod-app", cfg.App.Name)
+	require.Equal(t, "ap-northeast-1", cfg.App.Region)
+	require.Empty(t, buf.String())
+	require.Equal(t, []string{"main.hcl"}, loader.DiagnosticsWriter().Files())
+}
+
+func TestLoader__Error(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+app {
+  name   = "app"
+  region = var.region
+}
+`)},
+	}
+	var buf bytes.Buffer
+	loader := hclutil.NewLoader(
+		hclutil.WithLoaderFS(testFs),
+		hclutil.WithLoaderDiagnosticsOutput(&buf),
+	)
+	var cfg loaderTestConfig
+	err := loader.Load(context.Background(), &cfg)
+	var diagsErr *hclutil.DiagnosticsError
+	require.True(t, errors.As(err, &diagsErr))
+	require.Equal(t, 2, diagsErr.ErrorCount())
+	require.Contains(t, buf.String(), "Unknown variable")
+	require.Contains(t, buf.String(), "on main.hcl line 4")
+}
+
+func TestLoader__Strict(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl":  {Data: []byte("app {\n  name = \"app\"\n  region = \"us-east-1\"\n}\n")},
+		"README.md": {Data: []byte("# readme")},
+	}
+	var cfg loaderTestConfig
+	var buf bytes.Buffer
+	loader := hclutil.NewLoader(
+		hclutil.WithLoaderFS(testFs),
+		hclutil.WithLoaderDiagnosticsOutput(&buf),
+	)
+	require.NoError(t, loader.Load(context.Background(), &cfg))
+	require.Contains(t, buf.String(), "Warning: Unsupported file extension")
+
+	buf.Reset()
+	loader = hclutil.NewLoader(
+		hclutil.WithLoaderFS(testFs),
+		hclutil.WithLoaderDiagnosticsOutput(&buf),
+		hclutil.WithLoaderStrict(true),
+	)
+	// 設定以外のファイルを読み飛ばした警告は、strict でもエラーにならない
+	require.NoError(t, loader.Load(context.Background(), &cfg))
+	require.Contains(t, buf.String(), "Warning: Unsupported file extension")
+	require.True(t, loader.DiagnosticsWriter().WarningsAsErrors())
+
+	buf.Reset()
+	loader = hclutil.NewLoader(
+		hclutil.WithLoaderFS(testFs),
+		hclutil.WithLoaderDiagnosticsOutput(&buf),
+		hclutil.WithLoaderStrict(true),
+		hclutil.WithLoaderParseOptions(hclutil.WithUnsupportedFileWarnings(false)),
+	)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, loader.Load(context.Background(), &cfg))
+	}
+	require.Empty(t, buf.String())
+	require.Equal(t, "app", cfg.App.Name)
+}
+
+func TestLoader__Canceled(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loader := hclutil.NewLoader(hclutil.WithLoaderPath("testdata/hcl_file.hcl"))
+	var cfg struct {
+		Text string `hcl:"text"`
+	}
+	require.ErrorIs(t, loader.Load(ctx, &cfg), context.Canceled)
+}
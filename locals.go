@@ -14,7 +14,7 @@ func DecodeLocals(body hcl.Body, ctx *hcl.EvalContext) (hcl.Body, *hcl.EvalConte
 	})
 	localVariables := make(map[string]cty.Value)
 	if ctx != nil {
-		if v, ok := ctx.Variables["local"]; ok && !v.IsKnown() && !v.IsNull() {
+		if v, ok := ctx.Variables["local"]; ok && v.IsKnown() && !v.IsNull() {
 			ty := v.Type()
 			if ty.IsObjectType() || ty.IsMapType() {
 				localVariables = v.AsValueMap()
@@ -51,3 +51,39 @@ piyotora = local.piyo.tora
 		t.Errorf("unexpected length: %d", len(attrs))
 	}
 }
+
+func TestDecodeLocals__ExistingLocals(t *testing.T) {
+	t.Parallel()
+
+	file, diags := hclsyntax.ParseConfig([]byte(`locals { hoge = "hoge" }`), "first.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	_, ctx, diags := hclutil.DecodeLocals(file.Body, hclutil.NewEvalContext())
+	diagsReport(t, diags)
+
+	file, diags = hclsyntax.ParseConfig([]byte(`locals { fuga = "${local.hoge}-fuga" }`), "second.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	_, ctx, diags = hclutil.DecodeLocals(file.Body, ctx)
+	diagsReport(t, diags)
+	want := cty.ObjectVal(map[string]cty.Value{
+		"hoge": cty.StringVal("hoge"),
+		"fuga": cty.StringVal("hoge-fuga"),
+	})
+	if got := ctx.Variables["local"]; !got.RawEquals(want) {
+		t.Errorf("unexpected value: %s", got.GoString())
+	}
+
+	// 値が未定の local は引き継がず、パニックもしない
+	ctx = hclutil.WithVariables(hclutil.NewEvalContext(), map[string]cty.Value{
+		"local": cty.UnknownVal(cty.Object(map[string]cty.Type{"hoge": cty.String})),
+	})
+	file, diags = hclsyntax.ParseConfig([]byte(`locals { fuga = "fuga" }`), "third.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	_, ctx, diags = hclutil.DecodeLocals(file.Body, ctx)
+	diagsReport(t, diags)
+	want = cty.ObjectVal(map[string]cty.Value{
+		"fuga": cty.StringVal("fuga"),
+	})
+	if got := ctx.Variables["local"]; !got.RawEquals(want) {
+		t.Errorf("unexpected value: %s", got.GoString())
+	}
+}
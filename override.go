@@ -0,0 +1,164 @@
+package hclutil
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// NewOverrideBody は base に overrides を順に上書きした hcl.Body を返します。
+// Terraformのoverrideファイルと同様に、属性は後のものが置き換え、ブロックは種類とラベルが一致する最初のブロックに再帰的にマージされます。
+// 一致するブロックがない場合は、そのまま追加されます。
+// 必須の属性は、すべての上書きを適用した後に検査されます。
+//
+// NewOverrideBody returns an hcl.Body that applies overrides on top of base in order.
+// Like Terraform override files, attributes are replaced by later ones, and blocks are merged recursively
+// into the first block with the same type and labels. Blocks without a matching block are appended as they are.
+// Required attributes are checked after all overrides are applied.
+func NewOverrideBody(base hcl.Body, overrides ...hcl.Body) hcl.Body {
+	if len(overrides) == 0 {
+		return base
+	}
+	return &overrideBody{
+		base:      base,
+		overrides: overrides,
+	}
+}
+
+type overrideBody struct {
+	base      hcl.Body
+	overrides []hcl.Body
+}
+
+func (b *overrideBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	relaxed := relaxedSchema(schema)
+	content, diags := b.base.Content(relaxed)
+	for _, override := range b.overrides {
+		oc, d := override.Content(relaxed)
+		diags = append(diags, d...)
+		content = overrideContent(content, oc)
+	}
+	diags = append(diags, b.checkRequiredAttributes(schema, content)...)
+	return content, diags
+}
+
+func (b *overrideBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	relaxed := relaxedSchema(schema)
+	content, remain, diags := b.base.PartialContent(relaxed)
+	remains := make([]hcl.Body, 0, len(b.overrides))
+	for _, override := range b.overrides {
+		oc, r, d := override.PartialContent(relaxed)
+		diags = append(diags, d...)
+		content = overrideContent(content, oc)
+		remains = append(remains, r)
+	}
+	diags = append(diags, b.checkRequiredAttributes(schema, content)...)
+	return content, NewOverrideBody(remain, remains...), diags
+}
+
+func (b *overrideBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	attrs, diags := b.base.JustAttributes()
+	if attrs == nil {
+		attrs = make(hcl.Attributes)
+	}
+	for _, override := range b.overrides {
+		oa, d := override.JustAttributes()
+		diags = append(diags, d...)
+		for name, attr := range oa {
+			attrs[name] = attr
+		}
+	}
+	return attrs, diags
+}
+
+func (b *overrideBody) MissingItemRange() hcl.Range {
+	return b.base.MissingItemRange()
+}
+
+// relaxedSchema は 必須の属性を任意にしたスキーマを返します。必須の検査は上書きの後に行います。
+func relaxedSchema(schema *hcl.BodySchema) *hcl.BodySchema {
+	attrs := make([]hcl.AttributeSchema, len(schema.Attributes))
+	for i, attr := range schema.Attributes {
+		attr.Required = false
+		attrs[i] = attr
+	}
+	return &hcl.BodySchema{
+		Attributes: attrs,
+		Blocks:     schema.Blocks,
+	}
+}
+
+func overrideContent(base, override *hcl.BodyContent) *hcl.BodyContent {
+	if override == nil {
+		return base
+	}
+	if base == nil {
+		return override
+	}
+	attrs := make(hcl.Attributes, len(base.Attributes)+len(override.Attributes))
+	for name, attr := range base.Attributes {
+		attrs[name] = attr
+	}
+	for name, attr := range override.Attributes {
+		attrs[name] = attr
+	}
+	blocks := make(hcl.Blocks, len(base.Blocks))
+	copy(blocks, base.Blocks)
+	for _, ob := range override.Blocks {
+		matched := false
+		for i, block := range blocks {
+			if !sameBlockIdentity(block, ob) {
+				continue
+			}
+			merged := *block
+			merged.Body = NewOverrideBody(block.Body, ob.Body)
+			blocks[i] = &merged
+			matched = true
+			break
+		}
+		if !matched {
+			blocks = append(blocks, ob)
+		}
+	}
+	return &hcl.BodyContent{
+		Attributes:       attrs,
+		Blocks:           blocks,
+		MissingItemRange: base.MissingItemRange,
+	}
+}
+
+func sameBlockIdentity(a, b *hcl.Block) bool {
+	if a.Type != b.Type || len(a.Labels) != len(b.Labels) {
+		return false
+	}
+	for i := range a.Labels {
+		if a.Labels[i] != b.Labels[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRequiredAttributes は 上書きを適用した content に必須の属性があるかを検査します。
+// hcl.MergeBodies の結果など content に MissingItemRange がない場合は、body の MissingItemRange を使います。
+func (b *overrideBody) checkRequiredAttributes(schema *hcl.BodySchema, content *hcl.BodyContent) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if content.MissingItemRange == (hcl.Range{}) {
+		content.MissingItemRange = b.MissingItemRange()
+	}
+	for _, attr := range schema.Attributes {
+		if !attr.Required {
+			continue
+		}
+		if _, ok := content.Attributes[attr.Name]; ok {
+			continue
+		}
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required argument",
+			Detail:   fmt.Sprintf("The argument %q is required, but no definition was found.", attr.Name),
+			Subject:  content.MissingItemRange.Ptr(),
+		})
+	}
+	return diags
+}
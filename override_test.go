@@ -0,0 +1,170 @@
+package hclutil_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+)
+
+type overrideTestConfig struct {
+	Services []struct {
+		Name    string `hcl:"name,label"`
+		Image   string `hcl:"image"`
+		Port    int    `hcl:"port,optional"`
+		Healthy *struct {
+			Path     string `hcl:"path"`
+			Interval int    `hcl:"interval,optional"`
+		} `hcl:"health_check,block"`
+	} `hcl:"service,block"`
+	Region string `hcl:"region"`
+}
+
+func TestParseFS__Override(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+region = "ap-northeast-1"
+service "api" {
+  image = "api:latest"
+  port  = 8080
+  health_check {
+    path     = "/health"
+    interval = 10
+  }
+}
+service "worker" {
+  image = "worker:latest"
+}
+`)},
+		"override.hcl": {Data: []byte(`region = "us-east-1"`)},
+		"local_override.hcl.json": {Data: []byte(`{
+  "service": {
+    "api": {
+      "image": "api:dev",
+      "health_check": {"interval": 1}
+    },
+    "debug": {"image": "debug:latest"}
+  }
+}`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	var cfg overrideTestConfig
+	diags = gohcl.DecodeBody(body, hclutil.NewEvalContext(), &cfg)
+	diagsReport(t, diags)
+
+	require.Equal(t, "us-east-1", cfg.Region)
+	require.Len(t, cfg.Services, 3)
+	require.Equal(t, "api", cfg.Services[0].Name)
+	require.Equal(t, "api:dev", cfg.Services[0].Image)
+	require.Equal(t, 8080, cfg.Services[0].Port)
+	require.NotNil(t, cfg.Services[0].Healthy)
+	require.Equal(t, "/health", cfg.Services[0].Healthy.Path)
+	require.Equal(t, 1, cfg.Services[0].Healthy.Interval)
+	require.Equal(t, "worker", cfg.Services[1].Name)
+	require.Equal(t, "worker:latest", cfg.Services[1].Image)
+	require.Equal(t, "debug", cfg.Services[2].Name)
+	require.Equal(t, "debug:latest", cfg.Services[2].Image)
+}
+
+func TestParseFS__OverrideRequired(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl":          {Data: []byte(`service "api" {}`)},
+		"main_override.hcl": {Data: []byte(`region = "us-east-1"`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	var cfg overrideTestConfig
+	diags = gohcl.DecodeBody(body, hclutil.NewEvalContext(), &cfg)
+	require.True(t, diags.HasErrors())
+	require.Len(t, diags, 1)
+	require.Equal(t, "Missing required argument", diags[0].Summary)
+	require.Equal(t, `The argument "image" is required, but no definition was found.`, diags[0].Detail)
+}
+
+func TestParseFS__DuplicateAttributeWithoutOverride(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"a.hcl": {Data: []byte(`region = "ap-northeast-1"`)},
+		"b.hcl": {Data: []byte(`region = "us-east-1"`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	_, diags = body.JustAttributes()
+	require.True(t, diags.HasErrors())
+}
+
+func TestNewOverrideBody__JustAttributes(t *testing.T) {
+	t.Parallel()
+	base, _, diags := hclutil.ParseBytes("base.hcl", []byte("a = 1\nb = 2\n"))
+	diagsReport(t, diags)
+	override, _, diags := hclutil.ParseBytes("override.hcl", []byte("b = 3\nc = 4\n"))
+	diagsReport(t, diags)
+	attrs, diags := hclutil.NewOverrideBody(base, override).JustAttributes()
+	diagsReport(t, diags)
+	values := make(map[string]int, len(attrs))
+	for name, attr := range attrs {
+		var v int
+		diags := gohcl.DecodeExpression(attr.Expr, nil, &v)
+		diagsReport(t, diags)
+		values[name] = v
+	}
+	require.Equal(t, map[string]int{"a": 1, "b": 3, "c": 4}, values)
+	require.Equal(t, hcl.Pos{Line: 2, Column: 1, Byte: 6}, attrs["c"].Range.Start)
+}
+
+func TestParseFS__OverrideRequiredSubject(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"a.hcl":             {Data: []byte(`service "api" { image = "api:latest" }`)},
+		"b.hcl":             {Data: []byte(`service "worker" { image = "worker:latest" }`)},
+		"main_override.hcl": {Data: []byte(`service "api" { port = 8080 }`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	var cfg overrideTestConfig
+	diags = gohcl.DecodeBody(body, hclutil.NewEvalContext(), &cfg)
+	require.Len(t, diags, 1)
+	require.Equal(t, `The argument "region" is required, but no definition was found.`, diags[0].Detail)
+	require.NotNil(t, diags[0].Subject)
+	require.Equal(t, "a.hcl", diags[0].Subject.Filename)
+}
+
+func TestParseFS__OverrideRepeatedBlocks(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+locals {
+  a = 1
+}
+locals {
+  b = 2
+}
+`)},
+		"main_override.hcl": {Data: []byte(`
+locals {
+  a = 3
+}
+`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	content, diags := body.Content(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "locals"}},
+	})
+	diagsReport(t, diags)
+	require.Len(t, content.Blocks, 2)
+	first, diags := content.Blocks[0].Body.JustAttributes()
+	diagsReport(t, diags)
+	require.Len(t, first, 1)
+	require.Equal(t, "main_override.hcl", first["a"].Range.Filename)
+	second, diags := content.Blocks[1].Body.JustAttributes()
+	diagsReport(t, diags)
+	require.Len(t, second, 1)
+	require.Contains(t, second, "b")
+}
@@ -1,17 +1,23 @@
 package hclutil
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
 	"golang.org/x/term"
 )
 
@@ -32,12 +38,28 @@ type DiagnosticsWriter struct {
 	diagsOutput io.Writer
 	width       uint
 	color       bool
+	format      DiagnosticsFormat
+	filesMu     sync.RWMutex
 	files       map[string]*hcl.File
+
+	ignoreWarnings   bool
+	warningsAsErrors bool
+	deduplicate      bool
+	sorted           bool
+	maxDiagnostics   int
+
+	mu           sync.Mutex
+	errorCount   int
+	warningCount int
+	diagFiles    map[string]struct{}
+	seen         map[string]struct{}
 }
 
 // Files は Parse済みのファイルのPath名を返します。
 // Files returns the Path name of the parsed file.
 func (w *DiagnosticsWriter) Files() []string {
+	w.filesMu.RLock()
+	defer w.filesMu.RUnlock()
 	files := make([]string, 0, len(w.files))
 	for k := range w.files {
 		files = append(files, k)
@@ -105,26 +127,209 @@ func (w *DiagnosticsWriter) Width() uint {
 // WriteDiagnostics outputs diagnostic information.
 func (w *DiagnosticsWriter) WriteDiagnostics(diags hcl.Diagnostics) error {
 	w.once.Do(func() {
-		w.diagsWriter = hcl.NewDiagnosticTextWriter(w.diagsOutput, w.files, w.width, w.color)
+		switch w.format {
+		case DiagnosticsFormatJSON:
+			w.diagsWriter = &jsonDiagnosticWriter{wr: w.diagsOutput, files: w.files}
+		case DiagnosticsFormatSARIF:
+			w.diagsWriter = &sarifDiagnosticWriter{wr: w.diagsOutput, files: w.files}
+		case DiagnosticsFormatGitHubActions:
+			w.diagsWriter = &githubActionsDiagnosticWriter{wr: w.diagsOutput}
+		default:
+			w.diagsWriter = hcl.NewDiagnosticTextWriter(w.diagsOutput, w.files, w.width, w.color)
+		}
 	})
-	w.diagsWriter.WriteDiagnostics(diags)
+	diags = w.promoteDiagnostics(expandFunctionCallDiagnostics(diags))
+	written := w.filterDiagnostics(diags)
+	w.count(written)
+	shown, omitted := written, hcl.Diagnostics(nil)
+	if w.maxDiagnostics > 0 && len(written) > w.maxDiagnostics {
+		shown, omitted = written[:w.maxDiagnostics], written[w.maxDiagnostics:]
+	}
+	w.filesMu.RLock()
+	w.diagsWriter.WriteDiagnostics(redactDiagnostics(shown))
+	w.filesMu.RUnlock()
+	if len(omitted) > 0 {
+		w.writeOmitted(omitted)
+	}
 	if diags.HasErrors() {
-		return errors.New("diagnostics had errors, see above for details")
+		return &DiagnosticsError{Diagnostics: diags}
 	}
 	return nil
 }
 
+// redactDiagnostics は 診断情報が参照するEvalContextの値のうち、SensitiveMark が付与されたものを伏せ字にします。
+func redactDiagnostics(diags hcl.Diagnostics) hcl.Diagnostics {
+	redacted := make(hcl.Diagnostics, len(diags))
+	for i, diag := range diags {
+		if diag.EvalContext == nil {
+			redacted[i] = diag
+			continue
+		}
+		d := *diag
+		variables := make(map[string]cty.Value)
+		for current := diag.EvalContext; current != nil; current = current.Parent() {
+			for name, value := range current.Variables {
+				if _, ok := variables[name]; !ok {
+					variables[name], _ = RedactSensitive(value).UnmarkDeep()
+				}
+			}
+		}
+		d.EvalContext = &hcl.EvalContext{Variables: variables}
+		redacted[i] = &d
+	}
+	return redacted
+}
+
 func newDiagnosticsWriter(files map[string]*hcl.File) *DiagnosticsWriter {
+	// AddFile でパーサーのファイルの一覧を書き換えないように、写しを持つ
+	copied := make(map[string]*hcl.File, len(files))
+	for name, file := range files {
+		copied[name] = file
+	}
 	w := &DiagnosticsWriter{
-		files: files,
+		files: copied,
 	}
 	w.SetOutput(os.Stderr)
 	return w
 }
 
+type parseOptions struct {
+	extensions  map[string]FileFormat
+	concurrency int
+	cache       *ParseCache
+	cacheSeen   map[string]bool
+
+	ignoreUnsupportedFiles bool
+}
+
+func newParseOptions(optFns ...func(*parseOptions)) *parseOptions {
+	opts := &parseOptions{
+		extensions: map[string]FileFormat{
+			".hcl":      FileFormatHCL,
+			".hcl.json": FileFormatJSON,
+			".hcl.yaml": FileFormatYAML,
+			".hcl.yml":  FileFormatYAML,
+		},
+		concurrency: runtime.GOMAXPROCS(0),
+	}
+	for _, optFn := range optFns {
+		optFn(opts)
+	}
+	return opts
+}
+
+// WithFileExtension は 解析対象とするファイルの拡張子と構文を登録します。
+// 例えば WithFileExtension(".tf", FileFormatHCL) や WithFileExtension(".myapp.json", FileFormatJSON) のように指定します。
+// デフォルトでは .hcl, .hcl.json, .hcl.yaml, .hcl.yml が登録されています。
+//
+// WithFileExtension registers a file extension to be parsed and its syntax,
+// e.g. WithFileExtension(".tf", FileFormatHCL) or WithFileExtension(".myapp.json", FileFormatJSON).
+// .hcl, .hcl.json, .hcl.yaml and .hcl.yml are registered by default.
+func WithFileExtension(ext string, format FileFormat) func(*parseOptions) {
+	return func(opts *parseOptions) {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		opts.extensions[ext] = format
+	}
+}
+
+// WithParseConcurrency は ディレクトリを解析するときに並行して読み込み、解析するファイルの数の上限を指定します。
+// デフォルトは runtime.GOMAXPROCS(0) です。1 を指定すると順に解析します。
+//
+// WithParseConcurrency sets the maximum number of files read and parsed concurrently when parsing a directory.
+// The default is runtime.GOMAXPROCS(0). Specify 1 to parse files sequentially.
+func WithParseConcurrency(n int) func(*parseOptions) {
+	return func(opts *parseOptions) {
+		if n < 1 {
+			n = 1
+		}
+		opts.concurrency = n
+	}
+}
+
+// WithUnsupportedFileWarnings は ディレクトリの中の登録されていない拡張子のファイルを読み飛ばしたときに警告するかどうかを指定します。
+// デフォルトは true です。README.md などを置いたディレクトリを繰り返し読み込む場合に false にします。
+//
+// WithUnsupportedFileWarnings sets whether to warn about files in a directory skipped because of an unregistered extension.
+// The default is true. Set false when repeatedly loading a directory that also holds files such as README.md.
+func WithUnsupportedFileWarnings(warn bool) func(*parseOptions) {
+	return func(opts *parseOptions) {
+		opts.ignoreUnsupportedFiles = !warn
+	}
+}
+
+// unsupportedFileDiagExtra は 読み飛ばしたファイルの警告に付けられ、SetWarningsAsErrors でもエラーにしないことを示します。
+type unsupportedFileDiagExtra struct{}
+
+func isUnsupportedFileDiagnostic(diag *hcl.Diagnostic) bool {
+	_, ok := hcl.DiagnosticExtra[unsupportedFileDiagExtra](diag)
+	return ok
+}
+
+func (opts *parseOptions) workers(jobs int) int {
+	if jobs < opts.concurrency {
+		return jobs
+	}
+	return opts.concurrency
+}
+
+// extensionOf は 登録された拡張子のうち、name に一致する最も長いものを返します。
+func (opts *parseOptions) extensionOf(name string) string {
+	var matched string
+	for ext := range opts.extensions {
+		if strings.HasSuffix(name, ext) && len(ext) > len(matched) {
+			matched = ext
+		}
+	}
+	return matched
+}
+
+// formatOf は 登録された拡張子のうち、name に一致する最も長いものの構文を返します。
+func (opts *parseOptions) formatOf(name string) (FileFormat, bool) {
+	ext := opts.extensionOf(name)
+	if ext == "" {
+		return FileFormatAuto, false
+	}
+	return opts.extensions[ext], true
+}
+
+// isOverrideFile は override.hcl や *_override.hcl のような上書き用のファイルかを返します。
+func (opts *parseOptions) isOverrideFile(name string) bool {
+	stem := strings.TrimSuffix(filepath.Base(name), opts.extensionOf(name))
+	return stem == "override" || strings.HasSuffix(stem, "_override")
+}
+
+// mergeFiles は 上書き用以外のファイルをマージし、その後に上書き用のファイルを順に適用します。
+func (opts *parseOptions) mergeFiles(names []string, files []*hcl.File) hcl.Body {
+	var primaries []*hcl.File
+	var overrides []hcl.Body
+	for i, file := range files {
+		if opts.isOverrideFile(names[i]) {
+			overrides = append(overrides, file.Body)
+			continue
+		}
+		primaries = append(primaries, file)
+	}
+	return NewOverrideBody(hcl.MergeFiles(primaries), overrides...)
+}
+
+func (opts *parseOptions) extensionList() string {
+	exts := make([]string, 0, len(opts.extensions))
+	for ext := range opts.extensions {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return strings.Join(exts, ", ")
+}
+
 // Parse は与えられたPathをHCLとして解析します。
 // Parse parses the given Path as HCL.
-func Parse(p string) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+func Parse(p string, optFns ...func(*parseOptions)) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	return parsePath(p, newParseOptions(optFns...))
+}
+
+func parsePath(p string, opts *parseOptions) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
 	parser := hclparse.NewParser()
 	stat, err := os.Stat(p)
 	if err != nil {
@@ -135,31 +340,87 @@ func Parse(p string) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
 		}}
 	}
 	if !stat.IsDir() {
-		switch filepath.Ext(p) {
-		case ".hcl":
-			file, diags := parser.ParseHCLFile(p)
-			return file.Body, newDiagnosticsWriter(parser.Files()), diags
-		case ".json":
-			file, diags := parser.ParseJSONFile(p)
-			return file.Body, newDiagnosticsWriter(parser.Files()), diags
-		default:
+		format, ok := opts.formatOf(filepath.Base(p))
+		if !ok {
 			return nil, newDiagnosticsWriter(parser.Files()), hcl.Diagnostics{{
 				Severity: hcl.DiagError,
 				Summary:  "Unsupported file extension",
-				Detail:   "Only .hcl and .json are supported",
+				Detail:   fmt.Sprintf("Only %s are supported", opts.extensionList()),
+			}}
+		}
+		src, err := os.ReadFile(p)
+		if err != nil {
+			return nil, newDiagnosticsWriter(parser.Files()), hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Parse failed",
+				Detail:   err.Error(),
 			}}
 		}
+		if format == FileFormatAuto {
+			format = opts.detectFormat(p, src)
+		}
+		file, diags := opts.parseSource(parser, p, src, format)
+		return fileBody(file), newDiagnosticsWriter(parser.Files()), diags
 	}
-	return parseFS(p, parser, os.DirFS(p))
+	return parseFS(p, parser, os.DirFS(p), opts)
 }
 
 // ParseFS は与えられたfs.ReadDirFSをHCLとして解析します。
-func ParseFS(fsys fs.FS) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+// override.hcl や *_override.hcl のような上書き用のファイルは、他のファイルをマージした後に NewOverrideBody の規則で適用されます。
+func ParseFS(fsys fs.FS, optFns ...func(*parseOptions)) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	return parseFS("", hclparse.NewParser(), fsys, newParseOptions(optFns...))
+}
+
+// ParseLayers は fsys の中の複数のディレクトリを層として解析し、後の層を前の層に上書きした Body を返します。
+// 各層は ParseFS と同じ規則で解析され、層の間は NewOverrideBody の規則(属性は置き換え、ブロックは種類とラベルでマージ)で重ねられます。
+// 診断情報のファイル名には層のパスが含まれるため、値がどの層から来たかがわかります。
+// optFns には ParseFS と同じオプションを指定でき、すべての層に適用されます。
+//
+// ParseLayers parses multiple directories in fsys as layers and returns a Body where later layers override earlier ones.
+// Each layer is parsed with the same rules as ParseFS, and layers are stacked with the rules of NewOverrideBody
+// (attributes are replaced, blocks are merged by type and labels).
+// File names in diagnostics include the layer path, so they show which layer a value came from.
+// optFns accepts the same options as ParseFS, and they apply to every layer.
+//
+//	body, writer, diags := hclutil.ParseLayers(os.DirFS("config"), []string{"base", "envs/prod"})
+func ParseLayers(fsys fs.FS, layers []string, optFns ...func(*parseOptions)) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	opts := newParseOptions(optFns...)
 	parser := hclparse.NewParser()
-	return parseFS("", parser, fsys)
+	var diags hcl.Diagnostics
+	bodies := make([]hcl.Body, 0, len(layers))
+	for _, layer := range layers {
+		layer = path.Clean(layer)
+		sub, err := fs.Sub(fsys, layer)
+		if err != nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Failed to read layer %s", layer),
+				Detail:   err.Error(),
+			})
+			continue
+		}
+		prefix := layer
+		if prefix == "." {
+			prefix = ""
+		}
+		body, _, d := parseFS(prefix, parser, sub, opts)
+		for _, diag := range d {
+			if diag.Subject == nil {
+				diag.Summary = fmt.Sprintf("%s (layer %s)", diag.Summary, layer)
+			}
+		}
+		diags = append(diags, d...)
+		if body != nil {
+			bodies = append(bodies, body)
+		}
+	}
+	if len(bodies) == 0 {
+		return hcl.EmptyBody(), newDiagnosticsWriter(parser.Files()), diags
+	}
+	return NewOverrideBody(bodies[0], bodies[1:]...), newDiagnosticsWriter(parser.Files()), diags
 }
 
-func parseFS(path string, parser *hclparse.Parser, fsys fs.FS) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+func parseFS(path string, parser *hclparse.Parser, fsys fs.FS, opts *parseOptions) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
 	entires, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, newDiagnosticsWriter(parser.Files()), hcl.Diagnostics{{
@@ -168,76 +429,263 @@ func parseFS(path string, parser *hclparse.Parser, fsys fs.FS) (hcl.Body, *Diagn
 			Detail:   err.Error(),
 		}}
 	}
-	var diags hcl.Diagnostics
-	var files []*hcl.File
+	results := make([]parseFSResult, 0, len(entires))
 	for _, entry := range entires {
 		if entry.IsDir() {
 			continue
 		}
-		opener := func() ([]byte, bool) {
-			f, err := fsys.Open(entry.Name())
-			if err != nil {
-				diags = append(diags, &hcl.Diagnostic{
-					Severity: hcl.DiagError,
-					Summary:  fmt.Sprintf("Failed to open file %s", entry.Name()),
-					Detail:   err.Error(),
-				})
-				return nil, false
-			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					diags = append(diags, &hcl.Diagnostic{
-						Severity: hcl.DiagError,
-						Summary:  fmt.Sprintf("Failed to close file %s", entry.Name()),
-						Detail:   err.Error(),
-					})
-				}
-			}()
-			bs, err := io.ReadAll(f)
-			if err != nil {
-				diags = append(diags, &hcl.Diagnostic{
-					Severity: hcl.DiagError,
-					Summary:  fmt.Sprintf("Failed to read file %s", entry.Name()),
-					Detail:   err.Error(),
-				})
-				return nil, false
-			}
-			return bs, true
-		}
-
-		ext := filepath.Ext(entry.Name())
 		entryPath := filepath.Join(path, entry.Name())
-		// if ext is .hcl execute parser.ParseHCL
-		if ext == ".hcl" {
-			bs, ok := opener()
-			if !ok {
+		format, ok := opts.formatOf(entry.Name())
+		if !ok {
+			if opts.ignoreUnsupportedFiles {
 				continue
 			}
-			file, d := parser.ParseHCL(bs, entryPath)
-			files = append(files, file)
-			diags = append(diags, d...)
+			results = append(results, parseFSResult{
+				diags: hcl.Diagnostics{{
+					Severity: hcl.DiagWarning,
+					Summary:  "Unsupported file extension",
+					Detail:   fmt.Sprintf("File %s was skipped. Only %s are supported", entryPath, opts.extensionList()),
+					Extra:    unsupportedFileDiagExtra{},
+				}},
+			})
 			continue
 		}
-		// if entity.Name() is *.hcl.json execute parser.ParseJSONFile
-		if ext != ".json" {
-			continue
+		results = append(results, parseFSResult{
+			name:   entry.Name(),
+			path:   entryPath,
+			format: format,
+			parse:  true,
+		})
+	}
+
+	// ファイルの読み込みと解析は並行して行い、結果はディレクトリの順序のまま扱う
+	jobs := make(chan *parseFSResult)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers(len(results)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				r.run(fsys, parser, opts)
+			}
+		}()
+	}
+	for i := range results {
+		if results[i].parse {
+			jobs <- &results[i]
 		}
-		bs, ok := opener()
-		if !ok {
+	}
+	close(jobs)
+	wg.Wait()
+
+	var diags hcl.Diagnostics
+	var names []string
+	var files []*hcl.File
+	for _, r := range results {
+		diags = append(diags, r.diags...)
+		if r.file == nil {
 			continue
 		}
-		baseName := filepath.Base(entry.Name())
-		fileNameWithoutExt := baseName[:len(baseName)-len(ext)]
-		if filepath.Ext(fileNameWithoutExt) == ".hcl" {
-			file, d := parser.ParseJSON(bs, entryPath)
+		// hclparse.Parser は並行して使えないため、登録は解析がすべて終わった後に順に行う
+		if _, ok := parser.Files()[r.path]; !ok {
+			parser.AddFile(r.path, r.file)
+		}
+		names = append(names, r.path)
+		files = append(files, r.file)
+	}
+	return opts.mergeFiles(names, files), newDiagnosticsWriter(parser.Files()), diags
+}
+
+type parseFSResult struct {
+	name   string
+	path   string
+	format FileFormat
+	parse  bool
+
+	file  *hcl.File
+	diags hcl.Diagnostics
+}
+
+// run は ファイルを読み込んで解析します。parser は既に解析済みのファイルを探すためだけに使います。
+func (r *parseFSResult) run(fsys fs.FS, parser *hclparse.Parser, opts *parseOptions) {
+	if file, ok := parser.Files()[r.path]; ok {
+		r.file = file
+		return
+	}
+	bs, d := readFSFile(fsys, r.name)
+	r.diags = append(r.diags, d...)
+	if d.HasErrors() {
+		return
+	}
+	format := r.format
+	if format == FileFormatAuto {
+		format = opts.detectFormat(r.path, bs)
+	}
+	file, d := opts.parseFile(r.path, bs, format)
+	r.diags = append(r.diags, d...)
+	r.file = file
+}
+
+func readFSFile(fsys fs.FS, name string) (bs []byte, diags hcl.Diagnostics) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Failed to open file %s", name),
+			Detail:   err.Error(),
+		})
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Failed to close file %s", name),
+				Detail:   err.Error(),
+			})
+		}
+	}()
+	bs, err = io.ReadAll(f)
+	if err != nil {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Failed to read file %s", name),
+			Detail:   err.Error(),
+		})
+	}
+	return bs, diags
+}
+
+// FileFormat は 設定ファイルの構文を表します。
+// FileFormat represents the syntax of a configuration file.
+type FileFormat int
+
+const (
+	// FileFormatAuto は ファイル名や内容から構文を推測します。
+	// FileFormatAuto detects the syntax from the file name or the content.
+	FileFormatAuto FileFormat = iota
+	// FileFormatHCL は HCLのネイティブ構文です。
+	// FileFormatHCL is the HCL native syntax.
+	FileFormatHCL
+	// FileFormatJSON は HCLのJSON構文です。
+	// FileFormatJSON is the HCL JSON syntax.
+	FileFormatJSON
+	// FileFormatYAML は YAMLで書かれた設定です。JSON構文と同じ規則でBodyに変換されます。
+	// FileFormatYAML is a configuration written in YAML. It is converted into a Body with the same rules as the JSON syntax.
+	FileFormatYAML
+)
+
+// ParseBytes は 与えられたバイト列をHCLとして解析します。構文は登録された拡張子と内容から推測します。
+// ParseBytes parses the given bytes as HCL. The syntax is detected from the registered extensions and the content.
+func ParseBytes(name string, src []byte, optFns ...func(*parseOptions)) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	opts := newParseOptions(optFns...)
+	parser := hclparse.NewParser()
+	file, diags := opts.parseSource(parser, name, src, opts.detectFormat(name, src))
+	return fileBody(file), newDiagnosticsWriter(parser.Files()), diags
+}
+
+// ParseReader は io.Reader から読み込んだ内容を指定された構文で解析します。FileFormatAuto の場合は推測します。
+// ParseReader parses the content read from r with the given syntax. FileFormatAuto detects the syntax.
+func ParseReader(name string, r io.Reader, format FileFormat, optFns ...func(*parseOptions)) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	opts := newParseOptions(optFns...)
+	parser := hclparse.NewParser()
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, newDiagnosticsWriter(parser.Files()), hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Failed to read %s", name),
+			Detail:   err.Error(),
+		}}
+	}
+	if format == FileFormatAuto {
+		format = opts.detectFormat(name, src)
+	}
+	file, diags := opts.parseSource(parser, name, src, format)
+	return fileBody(file), newDiagnosticsWriter(parser.Files()), diags
+}
+
+// ParseSources は 複数のソースをHCLとして解析し、1つのBodyにマージします。
+// 構文はそれぞれの名前と内容から推測し、名前の順に解析します。
+// 上書き用のソースは ParseFS と同様に最後に適用されます。
+//
+// ParseSources parses multiple sources as HCL and merges them into one Body.
+// The syntax of each source is detected from its name and content, and sources are parsed in name order.
+// Override sources such as override.hcl or *_override.hcl are applied after the others, like ParseFS.
+func ParseSources(sources map[string][]byte, optFns ...func(*parseOptions)) (hcl.Body, *DiagnosticsWriter, hcl.Diagnostics) {
+	opts := newParseOptions(optFns...)
+	parser := hclparse.NewParser()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var diags hcl.Diagnostics
+	parsed := make([]string, 0, len(names))
+	files := make([]*hcl.File, 0, len(names))
+	for _, name := range names {
+		src := sources[name]
+		file, d := opts.parseSource(parser, name, src, opts.detectFormat(name, src))
+		diags = append(diags, d...)
+		if file != nil {
+			parsed = append(parsed, name)
 			files = append(files, file)
-			diags = append(diags, d...)
 		}
 	}
-	return hcl.MergeFiles(files), newDiagnosticsWriter(parser.Files()), diags
+	return opts.mergeFiles(parsed, files), newDiagnosticsWriter(parser.Files()), diags
+}
+
+// detectFormat は 登録された拡張子、なければ内容の先頭の文字から構文を推測します。
+func (opts *parseOptions) detectFormat(name string, src []byte) FileFormat {
+	if format, ok := opts.formatOf(name); ok && format != FileFormatAuto {
+		return format
+	}
+	if trimmed := bytes.TrimSpace(src); len(trimmed) > 0 && trimmed[0] == '{' {
+		// HCLのネイティブ構文のBodyは '{' で始まることはない
+		return FileFormatJSON
+	}
+	return FileFormatHCL
+}
+
+// parseSource は src を解析して parser に登録します。既に同じ名前で解析済みの場合はそれを返します。
+func (opts *parseOptions) parseSource(parser *hclparse.Parser, name string, src []byte, format FileFormat) (*hcl.File, hcl.Diagnostics) {
+	if file, ok := parser.Files()[name]; ok {
+		return file, nil
+	}
+	file, diags := opts.parseFile(name, src, format)
+	if file != nil {
+		parser.AddFile(name, file)
+	}
+	return file, diags
+}
+
+// parseFile は src を解析します。ParseCache が設定されている場合は、内容が同じであれば前回の結果を返します。
+func (opts *parseOptions) parseFile(name string, src []byte, format FileFormat) (*hcl.File, hcl.Diagnostics) {
+	if opts.cache != nil {
+		return opts.cache.parseFile(name, src, format, opts.cacheSeen)
+	}
+	return parseFile(name, src, format)
+}
+
+// parseFile は src を解析します。hclparse.Parser を使わないため、並行して呼び出すことができます。
+func parseFile(name string, src []byte, format FileFormat) (*hcl.File, hcl.Diagnostics) {
+	switch format {
+	case FileFormatJSON:
+		return hcljson.Parse(src, name)
+	case FileFormatYAML:
+		return parseYAML(src, name)
+	default:
+		return hclsyntax.ParseConfig(src, name, hcl.Pos{Byte: 0, Line: 1, Column: 1})
+	}
+}
+
+func fileBody(file *hcl.File) hcl.Body {
+	if file == nil {
+		return nil
+	}
+	return file.Body
 }
 
-// ParseExpression は HCLの式をパースします。
+// ParseExpression は HCLの式をパースします。式には "<expr#1>" のような一意な名前が付けられますが、ソースコードは記録されません。
+// 診断情報にソースコードを表示するには DiagnosticsWriter.ParseExpression を使うか、DiagnosticsWriter.AddSource で登録してください。
 func ParseExpression(expr []byte) (hcl.Expression, hcl.Diagnostics) {
-	return hclsyntax.ParseExpression(expr, "temporary.hcl", hcl.Pos{Line: 1, Column: 1})
+	return hclsyntax.ParseExpression(expr, expressionFilename(), hcl.Pos{Line: 1, Column: 1})
 }
@@ -2,9 +2,13 @@ package hclutil_test
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/mashiike/hclutil"
 	"github.com/stretchr/testify/require"
 )
@@ -93,3 +97,192 @@ func TestParse__Dir__NotFound(t *testing.T) {
 	_, _, diags := hclutil.Parse("testdata/notfound")
 	require.EqualError(t, diags, "<nil>: Parse failed; stat testdata/notfound: no such file or directory")
 }
+
+func TestParseBytes(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		src  string
+	}{
+		{name: "config.hcl", src: "text = \"hoge\"\nnumber = 1.1\nboolean = true\n"},
+		{name: "config.hcl.json", src: `{"text": "hoge", "number": 1.1, "boolean": true}`},
+		{name: "config", src: "text = \"hoge\"\nnumber = 1.1\nboolean = true\n"},
+		{name: "config", src: ` {"text": "hoge", "number": 1.1, "boolean": true}`},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			body, writer, diags := hclutil.ParseBytes(c.name, []byte(c.src))
+			diagsReport(t, diags)
+			require.NotNil(t, body)
+			require.Equal(t, []string{c.name}, writer.Files())
+			attrs, _ := body.JustAttributes()
+			attrKeys := make([]string, 0, len(attrs))
+			for k := range attrs {
+				attrKeys = append(attrKeys, k)
+			}
+			require.ElementsMatch(t, []string{"boolean", "text", "number"}, attrKeys)
+		})
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.ParseReader("api", strings.NewReader(`{"text": "hoge"}`), hclutil.FileFormatJSON)
+	diagsReport(t, diags)
+	require.Equal(t, []string{"api"}, writer.Files())
+	attrs, _ := body.JustAttributes()
+	require.Contains(t, attrs, "text")
+
+	_, _, diags = hclutil.ParseReader("api", strings.NewReader(`{"text": "hoge"}`), hclutil.FileFormatHCL)
+	require.True(t, diags.HasErrors())
+}
+
+func TestParseSources(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.ParseSources(map[string][]byte{
+		"a.hcl":      []byte(`text = "hoge"`),
+		"b.hcl.json": []byte(`{"number": 1.1, "boolean": true}`),
+	})
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []string{"a.hcl", "b.hcl.json"}, writer.Files())
+	attrs, _ := body.JustAttributes()
+	attrKeys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		attrKeys = append(attrKeys, k)
+	}
+	require.ElementsMatch(t, []string{"boolean", "text", "number"}, attrKeys)
+}
+
+func TestParseFS__FileExtension(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.tf":         {Data: []byte(`text = "hoge"`)},
+		"vars.myapp.json": {Data: []byte(`{"number": 1.1}`)},
+		"config.hcl":      {Data: []byte(`boolean = true`)},
+		"README.md":       {Data: []byte(`# readme`)},
+	}
+	body, writer, diags := hclutil.ParseFS(testFs,
+		hclutil.WithFileExtension(".tf", hclutil.FileFormatHCL),
+		hclutil.WithFileExtension("myapp.json", hclutil.FileFormatJSON),
+	)
+	require.False(t, diags.HasErrors())
+	require.Len(t, diags, 1)
+	require.Equal(t, hcl.DiagWarning, diags[0].Severity)
+	require.Equal(t, "Unsupported file extension", diags[0].Summary)
+	require.Contains(t, diags[0].Detail, "File README.md was skipped")
+	require.ElementsMatch(t, []string{"main.tf", "vars.myapp.json", "config.hcl"}, writer.Files())
+	attrs, _ := body.JustAttributes()
+	attrKeys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		attrKeys = append(attrKeys, k)
+	}
+	require.ElementsMatch(t, []string{"boolean", "text", "number"}, attrKeys)
+}
+
+func TestParse__UnsupportedFileExtension(t *testing.T) {
+	t.Parallel()
+	_, _, diags := hclutil.Parse("testdata/simple/dummy.json")
+	require.EqualError(t, diags, "<nil>: Unsupported file extension; Only .hcl, .hcl.json, .hcl.yaml, .hcl.yml are supported")
+}
+
+func TestParseLayers(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"base/main.hcl": {Data: []byte(`
+region = "ap-northeast-1"
+service "api" {
+  image    = "api:latest"
+  replicas = 1
+}
+`)},
+		"envs/prod/main.hcl": {Data: []byte(`
+service "api" {
+  replicas = 3
+}
+`)},
+		"envs/prod/region.hcl.json": {Data: []byte(`{"region": "us-east-1"}`)},
+	}
+	body, writer, diags := hclutil.ParseLayers(testFs, []string{"base", "envs/prod/"})
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []string{"base/main.hcl", "envs/prod/main.hcl", "envs/prod/region.hcl.json"}, writer.Files())
+
+	var cfg struct {
+		Region   string `hcl:"region"`
+		Services []struct {
+			Name     string `hcl:"name,label"`
+			Image    string `hcl:"image"`
+			Replicas int    `hcl:"replicas"`
+		} `hcl:"service,block"`
+	}
+	diags = gohcl.DecodeBody(body, hclutil.NewEvalContext(), &cfg)
+	diagsReport(t, diags)
+	require.Equal(t, "us-east-1", cfg.Region)
+	require.Len(t, cfg.Services, 1)
+	require.Equal(t, "api:latest", cfg.Services[0].Image)
+	require.Equal(t, 3, cfg.Services[0].Replicas)
+
+	content, diags := body.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "region"}},
+		Blocks:     []hcl.BlockHeaderSchema{{Type: "service", LabelNames: []string{"name"}}},
+	})
+	diagsReport(t, diags)
+	require.Equal(t, "envs/prod/region.hcl.json", content.Attributes["region"].Range.Filename)
+}
+
+func TestParseLayers__Options(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"base/main.tf":      {Data: []byte(`region = "ap-northeast-1"`)},
+		"envs/prod/main.tf": {Data: []byte(`region = "us-east-1"`)},
+	}
+	body, writer, diags := hclutil.ParseLayers(testFs, []string{"base", "envs/prod"},
+		hclutil.WithFileExtension(".tf", hclutil.FileFormatHCL),
+		hclutil.WithParseConcurrency(1),
+	)
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []string{"base/main.tf", "envs/prod/main.tf"}, writer.Files())
+	attrs, diags := body.JustAttributes()
+	diagsReport(t, diags)
+	require.Equal(t, "envs/prod/main.tf", attrs["region"].Range.Filename)
+}
+
+func TestParseLayers__MissingLayer(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"base/main.hcl": {Data: []byte(`region = "ap-northeast-1"`)},
+	}
+	_, _, diags := hclutil.ParseLayers(testFs, []string{"base", "envs/dev"})
+	require.True(t, diags.HasErrors())
+	require.Equal(t, "Failed to read directory (layer envs/dev)", diags[0].Summary)
+}
+
+func TestParseFS__Concurrency(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{}
+	expectedFiles := make([]string, 0, 50)
+	var expectedDiagFiles []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("file%02d.hcl", i)
+		expectedFiles = append(expectedFiles, name)
+		if i%10 == 0 {
+			testFs[name] = &fstest.MapFile{Data: []byte(fmt.Sprintf("attr%02d = ", i))}
+			expectedDiagFiles = append(expectedDiagFiles, name)
+			continue
+		}
+		testFs[name] = &fstest.MapFile{Data: []byte(fmt.Sprintf("attr%02d = %d", i, i))}
+	}
+	for _, concurrency := range []int{1, 4, 100} {
+		body, writer, diags := hclutil.ParseFS(testFs, hclutil.WithParseConcurrency(concurrency))
+		require.True(t, diags.HasErrors())
+		diagFiles := make([]string, 0, len(diags))
+		for _, diag := range diags {
+			diagFiles = append(diagFiles, diag.Subject.Filename)
+		}
+		require.Equal(t, expectedDiagFiles, diagFiles, "concurrency=%d", concurrency)
+		require.ElementsMatch(t, expectedFiles, writer.Files(), "concurrency=%d", concurrency)
+		attrs, _ := body.JustAttributes()
+		require.Len(t, attrs, 50, "concurrency=%d", concurrency)
+	}
+}
@@ -0,0 +1,192 @@
+package hclutil
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// PartialDecodeLocals は DecodeLocals の部分評価版です。プレビューのように、まだ値が決まらない参照を含む設定を評価するときに使います。
+// 解決できない参照(未定義の変数や属性)は cty.DynamicVal (型が未定の unknown) として扱われ、それに依存する local も unknown になります。
+// local は他の local を参照でき、依存関係が解決できる順に評価されます。循環参照している local はエラーになり、unknown になります。
+//
+// PartialDecodeLocals is a partial-evaluation version of DecodeLocals, for evaluating configurations that
+// contain references whose values are not decided yet, as in a preview.
+// Unresolvable references (undefined variables or attributes) are treated as cty.DynamicVal (unknown of undecided type),
+// and locals depending on them become unknown too.
+// Locals can refer to other locals and are evaluated in the order their dependencies are resolved.
+// Locals in a reference cycle are reported as errors and become unknown.
+func PartialDecodeLocals(body hcl.Body, ctx *hcl.EvalContext) (hcl.Body, *hcl.EvalContext, hcl.Diagnostics) {
+	content, remain, diags := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "locals", LabelNames: []string{}},
+		},
+	})
+	localVariables := make(map[string]cty.Value)
+	if ctx != nil {
+		if v, ok := ctx.Variables["local"]; ok && v.IsKnown() && !v.IsNull() {
+			ty := v.Type()
+			if ty.IsObjectType() || ty.IsMapType() {
+				localVariables = v.AsValueMap()
+			}
+		}
+	}
+	pending := make(map[string]*hcl.Attribute)
+	for _, block := range content.Blocks {
+		attrs, d := ExtructAttributes(block.Body)
+		diags = diags.Extend(d)
+		for name, attr := range attrs {
+			pending[name] = attr
+		}
+	}
+
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		for _, name := range sortedKeys(pending) {
+			attr := pending[name]
+			if dependsOnPendingLocals(attr.Expr, pending) {
+				continue
+			}
+			evalCtx := WithVariables(ctx, map[string]cty.Value{
+				"local": cty.ObjectVal(localVariables),
+			})
+			v, d := attr.Expr.Value(PartialEvalContext(evalCtx, attr.Expr))
+			diags = diags.Extend(d)
+			if d.HasErrors() {
+				v = cty.DynamicVal
+			}
+			localVariables[name] = v
+			delete(pending, name)
+			progress = true
+		}
+	}
+	for _, name := range sortedKeys(pending) {
+		attr := pending[name]
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Circular reference in locals",
+			Detail:   fmt.Sprintf("The local value %q cannot be evaluated because it is part of a reference cycle.", name),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+		localVariables[name] = cty.DynamicVal
+	}
+	if len(localVariables) == 0 {
+		return remain, ctx, diags
+	}
+	ctxWithLocal := ctx.NewChild()
+	ctxWithLocal.Variables = map[string]cty.Value{
+		"local": cty.ObjectVal(localVariables),
+	}
+	return remain, ctxWithLocal, diags
+}
+
+// dependsOnPendingLocals は expr がまだ評価していない local を参照しているかを返します。
+// 自身への参照も含むため、`a = local.a` は循環参照として扱われます。
+func dependsOnPendingLocals(expr hcl.Expression, pending map[string]*hcl.Attribute) bool {
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		attr, ok := traversal[1].(hcl.TraverseAttr)
+		if !ok {
+			continue
+		}
+		if _, ok := pending[attr.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PartialEvalContext は expr が参照する変数のうち、ctx で解決できないものを cty.DynamicVal で補ったEvalContextを返します。
+// PartialEvalContext returns an EvalContext in which the variables referenced by expr that ctx cannot resolve are filled with cty.DynamicVal.
+func PartialEvalContext(ctx *hcl.EvalContext, expr hcl.Expression) *hcl.EvalContext {
+	placeholders := make(map[string]cty.Value)
+	visible := VisibleVariables(ctx)
+	for _, traversal := range expr.Variables() {
+		evalCtx := ctx
+		if len(placeholders) > 0 {
+			evalCtx = WithVariables(ctx, placeholders)
+		}
+		if _, diags := traversal.TraverseAbs(evalCtx); !diags.HasErrors() {
+			continue
+		}
+		root := traversal.RootName()
+		current, ok := placeholders[root]
+		if !ok {
+			current, ok = visible[root]
+		}
+		if !ok {
+			current = cty.NilVal
+		}
+		v, diags := setValuePath(current, traversal, 1, cty.DynamicVal)
+		if diags.HasErrors() {
+			// 途中の値の型が合わない場合などは、変数全体を unknown にする
+			v = cty.DynamicVal
+		}
+		placeholders[root] = v
+	}
+	if len(placeholders) == 0 {
+		return ctx
+	}
+	// WithVariables はオブジェクトをマージするため、置き換えた変数は子のEvalContextで直接上書きする
+	cctx := WithVariables(ctx, nil)
+	for root, v := range placeholders {
+		cctx.Variables[root] = v
+	}
+	return cctx
+}
+
+// PartialUnmarshalCTYValue は UnmarshalCTYValue の部分評価版です。
+// unknown な値はエラーにせずにゼロ値としてデコードし、そのパス(".a.b[0]" の形式)を返します。
+//
+// PartialUnmarshalCTYValue is a partial-evaluation version of UnmarshalCTYValue.
+// Unknown values are decoded as zero values instead of failing, and their paths (in the form ".a.b[0]") are returned.
+func PartialUnmarshalCTYValue(value cty.Value, v any) ([]string, error) {
+	unmarked, pvm := value.UnmarkDeepWithPaths()
+	var unknowns []string
+	replaced, err := cty.Transform(unmarked, func(path cty.Path, v cty.Value) (cty.Value, error) {
+		if v.IsKnown() {
+			return v, nil
+		}
+		unknowns = append(unknowns, ctyPathString(path))
+		return cty.NullVal(v.Type()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(unknowns)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return unknowns, &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	return unknowns, unmarshalCTYValue("", replaced.MarkWithPaths(pvm), rv)
+}
+
+// ctyPathString は cty.Path を unmarshalCTYValue のエラーと同じ形式の文字列にします。
+func ctyPathString(path cty.Path) string {
+	var b strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			b.WriteString("." + step.Name)
+		case cty.IndexStep:
+			key, _ := step.Key.Unmark()
+			switch {
+			case !key.IsKnown():
+				b.WriteString("[?]")
+			case key.Type() == cty.String:
+				b.WriteString("[" + key.AsString() + "]")
+			case key.Type() == cty.Number:
+				b.WriteString("[" + key.AsBigFloat().Text('f', -1) + "]")
+			default:
+				b.WriteString("[?]")
+			}
+		}
+	}
+	return b.String()
+}
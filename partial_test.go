@@ -0,0 +1,131 @@
+package hclutil_test
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestPartialDecodeLocals(t *testing.T) {
+	t.Parallel()
+	src := `
+locals {
+  url      = "https://${local.host}:${var.port}"
+  host     = "${local.prefix}.example.com"
+  prefix   = "api"
+  instance = aws_instance.web.id
+  name     = upper(local.instance)
+  count    = abs(var.undefined_number)
+}
+
+endpoint = local.url
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	ctx := hclutil.NewEvalContext()
+	ctx = hclutil.WithValue(ctx, "var.port", cty.NumberIntVal(443))
+	remain, ctx, diags := hclutil.PartialDecodeLocals(file.Body, ctx)
+	diagsReport(t, diags)
+
+	v, diags := hclutil.GetValue(ctx, "local.url")
+	diagsReport(t, diags)
+	require.Equal(t, cty.StringVal("https://api.example.com:443"), v)
+	v, diags = hclutil.GetValue(ctx, "local.instance")
+	diagsReport(t, diags)
+	require.False(t, v.IsKnown())
+	v, diags = hclutil.GetValue(ctx, "local.name")
+	diagsReport(t, diags)
+	require.False(t, v.IsKnown())
+	require.Equal(t, cty.String, v.Type())
+	v, diags = hclutil.GetValue(ctx, "local.count")
+	diagsReport(t, diags)
+	require.False(t, v.IsKnown())
+	require.Equal(t, cty.Number, v.Type())
+
+	attrs, diags := hclutil.ExtructAttributes(remain)
+	diagsReport(t, diags)
+	v, diags = attrs["endpoint"].Expr.Value(ctx)
+	diagsReport(t, diags)
+	require.Equal(t, cty.StringVal("https://api.example.com:443"), v)
+}
+
+func TestPartialDecodeLocals__ExistingLocals(t *testing.T) {
+	t.Parallel()
+	file, diags := hclsyntax.ParseConfig([]byte(`locals { fuga = "fuga" }`), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	parent := hclutil.WithVariables(hclutil.NewEvalContext(), map[string]cty.Value{
+		"local": cty.ObjectVal(map[string]cty.Value{"hoge": cty.StringVal("hoge")}),
+	})
+	for _, ctx := range []*hcl.EvalContext{parent, parent.NewChild()} {
+		_, want, diags := hclutil.DecodeLocals(file.Body, ctx)
+		diagsReport(t, diags)
+		_, got, diags := hclutil.PartialDecodeLocals(file.Body, ctx)
+		diagsReport(t, diags)
+		require.Equal(t, want.Variables["local"], got.Variables["local"])
+	}
+}
+
+func TestPartialDecodeLocals__Cycle(t *testing.T) {
+	t.Parallel()
+	src := `
+locals {
+  a = local.b
+  b = local.a
+  c = "ok"
+  d = "${local.d}-suffix"
+}
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	_, ctx, diags := hclutil.PartialDecodeLocals(file.Body, hclutil.NewEvalContext())
+	require.Len(t, diags, 3)
+	require.EqualError(t, diags, `test.hcl:3,7-14: Circular reference in locals; The local value "a" cannot be evaluated because it is part of a reference cycle., and 2 other diagnostic(s)`)
+	require.Equal(t, `The local value "d" cannot be evaluated because it is part of a reference cycle.`, diags[2].Detail)
+	require.Equal(t, 6, diags[2].Subject.Start.Line)
+	v, _ := hclutil.GetValue(ctx, "local.c")
+	require.Equal(t, cty.StringVal("ok"), v)
+	v, _ = hclutil.GetValue(ctx, "local.d")
+	require.False(t, v.IsKnown())
+}
+
+func TestPartialUnmarshalCTYValue(t *testing.T) {
+	t.Parallel()
+	type server struct {
+		Name string `cty:"name"`
+		IP   string `cty:"ip"`
+	}
+	type config struct {
+		Name    string            `cty:"name"`
+		ID      *string           `cty:"id"`
+		Servers []server          `cty:"servers"`
+		Tags    map[string]string `cty:"tags"`
+	}
+	value := cty.ObjectVal(map[string]cty.Value{
+		"name": cty.StringVal("app"),
+		"id":   cty.UnknownVal(cty.String),
+		"servers": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("web"), "ip": cty.UnknownVal(cty.String)}),
+		}),
+		"tags": cty.MapVal(map[string]cty.Value{
+			"env":   cty.StringVal("prod"),
+			"owner": cty.DynamicVal,
+		}),
+	})
+	var cfg config
+	err := hclutil.UnmarshalCTYValue(value, &cfg)
+	require.EqualError(t, err, "hclutil: unknown value cty.UnknownVal(cty.String) [.id]")
+
+	var partial config
+	unknowns, err := hclutil.PartialUnmarshalCTYValue(value, &partial)
+	require.NoError(t, err)
+	require.Equal(t, []string{".id", ".servers[0].ip", ".tags[owner]"}, unknowns)
+	require.Equal(t, config{
+		Name:    "app",
+		Servers: []server{{Name: "web"}},
+		Tags:    map[string]string{"env": "prod", "owner": ""},
+	}, partial)
+}
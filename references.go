@@ -0,0 +1,218 @@
+package hclutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// Reference は 式が参照している変数です。
+// Reference is a variable referenced by an expression.
+type Reference struct {
+	// Traversal は 参照している変数のトラバーサルです。 Traversal is the traversal of the referenced variable.
+	Traversal hcl.Traversal
+	// Attribute は 参照を含む属性の名前です。 Attribute is the name of the attribute that contains the reference.
+	Attribute string
+	// Range は ソース上の参照の位置です。 Range is the source range of the reference.
+	Range hcl.Range
+}
+
+// String は TraversalToString と同じ形式で参照を返します。
+// String returns the reference in the same format as TraversalToString.
+func (r Reference) String() string {
+	return TraversalToString(r.Traversal)
+}
+
+// BlockAddress は ブロックの種類とラベルを "." でつないだアドレスを返します。例えば `service "api" {}` は "service.api" です。
+// BlockAddress returns the address of the block, its type and labels joined by ".". For example `service "api" {}` is "service.api".
+func BlockAddress(block *hcl.Block) string {
+	return strings.Join(append([]string{block.Type}, block.Labels...), ".")
+}
+
+// BodyReferences は schema に従って body を解析し、アドレスごとにそこで参照されている変数を返します。
+// schema に含まれるブロックは BlockAddress のアドレスで、ネストしたブロックの参照も含めてまとめられます。
+// schema に含まれる属性は属性の名前がアドレスになります。
+// locals ブロックは属性ごとに "local.<name>" のアドレスになります。
+// ネイティブ構文とJSON構文のどちらにも対応しています。schema に含まれないブロックや属性は無視されます。
+// override ファイルを含む body では、上書きで置き換えられた属性の参照は含まれません。
+//
+// BodyReferences analyzes body according to schema and returns the variables referenced at each address.
+// Blocks in schema are keyed by BlockAddress, including references in their nested blocks.
+// Attributes in schema are keyed by their name.
+// locals blocks are keyed per attribute as "local.<name>".
+// Both native and JSON syntax are supported. Blocks and attributes not in schema are ignored.
+// For bodies with override files, references of attributes replaced by an override are not included.
+//
+//	refs, diags := hclutil.BodyReferences(body, &hcl.BodySchema{
+//		Blocks: []hcl.BlockHeaderSchema{{Type: "service", LabelNames: []string{"name"}}},
+//	})
+//	for _, ref := range refs["service.api"] {
+//		fmt.Println(ref, ref.Range)
+//	}
+func BodyReferences(body hcl.Body, schema *hcl.BodySchema) (map[string][]Reference, hcl.Diagnostics) {
+	content, _, diags := body.PartialContent(schema)
+	refs := make(map[string][]Reference)
+	for _, attr := range sortedAttributes(content.Attributes) {
+		refs[attr.Name] = appendReferences(refs[attr.Name], attr)
+	}
+	for _, block := range content.Blocks {
+		if block.Type == "locals" && len(block.Labels) == 0 {
+			attrs, d := ExtructAttributes(block.Body)
+			diags = diags.Extend(d)
+			for _, attr := range sortedAttributes(attrs) {
+				addr := "local." + attr.Name
+				refs[addr] = appendReferences(refs[addr], attr)
+			}
+			continue
+		}
+		addr := BlockAddress(block)
+		r, d := bodyReferences(block.Body)
+		diags = diags.Extend(d)
+		refs[addr] = append(refs[addr], r...)
+	}
+	return refs, diags
+}
+
+// bodyReferences は スキーマなしで body のすべての属性とネストしたブロックの参照を集めます。
+// NewOverrideBody の body は、上書きの規則に従って置き換えられた属性を除き、各層のブロックを種類とラベルが一致する最初のブロックにまとめて集めます。
+func bodyReferences(body hcl.Body) ([]Reference, hcl.Diagnostics) {
+	return layeredBodyReferences(overrideLayers(body, nil))
+}
+
+// overrideLayers は NewOverrideBody の body を上書きの順に並べた層に展開します。
+func overrideLayers(body hcl.Body, layers []hcl.Body) []hcl.Body {
+	ob, ok := body.(*overrideBody)
+	if !ok {
+		return append(layers, body)
+	}
+	layers = overrideLayers(ob.base, layers)
+	for _, override := range ob.overrides {
+		layers = overrideLayers(override, layers)
+	}
+	return layers
+}
+
+func layeredBodyReferences(layers []hcl.Body) ([]Reference, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	attrs := make(hcl.Attributes)
+	var groups [][]*hcl.Block
+	for i, layer := range layers {
+		syntaxBody, ok := layer.(*hclsyntax.Body)
+		if !ok {
+			// JSON構文ではネストしたブロックも属性として扱われ、その式から参照を集められる
+			a, d := ExtructAttributes(layer)
+			diags = diags.Extend(d)
+			for name, attr := range a {
+				attrs[name] = attr
+			}
+			continue
+		}
+		for name, attr := range syntaxBody.Attributes {
+			attrs[name] = attr.AsHCLAttribute()
+		}
+		for _, block := range syntaxBody.Blocks {
+			block := block.AsHCLBlock()
+			matched := false
+			if i > 0 {
+				for j, group := range groups {
+					if sameBlockIdentity(group[0], block) {
+						groups[j] = append(group, block)
+						matched = true
+						break
+					}
+				}
+			}
+			if !matched {
+				groups = append(groups, []*hcl.Block{block})
+			}
+		}
+	}
+	var refs []Reference
+	for _, attr := range sortedAttributes(attrs) {
+		refs = appendReferences(refs, attr)
+	}
+	for _, group := range groups {
+		var nested []hcl.Body
+		for _, block := range group {
+			nested = overrideLayers(block.Body, nested)
+		}
+		r, d := layeredBodyReferences(nested)
+		diags = diags.Extend(d)
+		refs = append(refs, r...)
+	}
+	return refs, diags
+}
+
+func appendReferences(refs []Reference, attr *hcl.Attribute) []Reference {
+	for _, traversal := range attr.Expr.Variables() {
+		refs = append(refs, Reference{
+			Traversal: traversal,
+			Attribute: attr.Name,
+			Range:     traversal.SourceRange(),
+		})
+	}
+	return refs
+}
+
+func sortedAttributes(attrs hcl.Attributes) []*hcl.Attribute {
+	sorted := make([]*hcl.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		sorted = append(sorted, attr)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rangeLess(sorted[i].Range, sorted[j].Range)
+	})
+	return sorted
+}
+
+func rangeLess(a, b hcl.Range) bool {
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	return a.Start.Byte < b.Start.Byte
+}
+
+// ValidateLocalReferences は body 全体で定義されていない local を参照している箇所をエラーとして返します。
+// 評価の前に参照の誤りを見つけるために使います。schema は BodyReferences と同じで、locals ブロックは自動的に含まれます。
+//
+// ValidateLocalReferences returns errors for references to locals that are not defined anywhere in body.
+// It is used to find wrong references before evaluation. schema is the same as BodyReferences, and locals blocks are always included.
+func ValidateLocalReferences(body hcl.Body, schema *hcl.BodySchema) hcl.Diagnostics {
+	withLocals := &hcl.BodySchema{
+		Attributes: schema.Attributes,
+		Blocks:     []hcl.BlockHeaderSchema{{Type: "locals"}},
+	}
+	for _, block := range schema.Blocks {
+		if block.Type != "locals" {
+			withLocals.Blocks = append(withLocals.Blocks, block)
+		}
+	}
+	refs, diags := BodyReferences(body, withLocals)
+	defined := make(map[string]bool)
+	for addr := range refs {
+		if strings.HasPrefix(addr, "local.") {
+			defined[strings.TrimPrefix(addr, "local.")] = true
+		}
+	}
+	for _, addr := range sortedKeys(refs) {
+		for _, ref := range refs[addr] {
+			if ref.Traversal.RootName() != "local" || len(ref.Traversal) < 2 {
+				continue
+			}
+			attr, ok := ref.Traversal[1].(hcl.TraverseAttr)
+			if !ok || defined[attr.Name] {
+				continue
+			}
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Reference to undeclared local value",
+				Detail:   fmt.Sprintf("A local value with the name %q has not been declared.", attr.Name),
+				Subject:  ref.Range.Ptr(),
+			})
+		}
+	}
+	return diags
+}
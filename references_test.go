@@ -0,0 +1,198 @@
+package hclutil_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+)
+
+var referencesSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "endpoint"},
+	},
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "locals"},
+		{Type: "database", LabelNames: []string{"name"}},
+		{Type: "service", LabelNames: []string{"name"}},
+	},
+}
+
+func referenceStrings(refs []hclutil.Reference) []string {
+	strs := make([]string, len(refs))
+	for i, ref := range refs {
+		strs[i] = ref.String()
+	}
+	return strs
+}
+
+func TestBodyReferences(t *testing.T) {
+	t.Parallel()
+	src := `
+locals {
+  region = var.region
+  prefix = "app-${local.region}"
+}
+
+database "main" {
+  host = "${local.prefix}.db"
+}
+
+service "api" {
+  url  = database.main.url
+  port = var.ports[0]
+  health_check {
+    path = var.health["path"]
+  }
+}
+
+endpoint = service.api.url
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	refs, diags := hclutil.BodyReferences(file.Body, referencesSchema)
+	diagsReport(t, diags)
+
+	require.Equal(t, map[string][]string{
+		"endpoint":      {"service.api.url"},
+		"local.region":  {"var.region"},
+		"local.prefix":  {"local.region"},
+		"database.main": {"local.prefix"},
+		"service.api":   {"database.main.url", "var.ports[0]", `var.health["path"]`},
+	}, func() map[string][]string {
+		m := make(map[string][]string, len(refs))
+		for addr, r := range refs {
+			m[addr] = referenceStrings(r)
+		}
+		return m
+	}())
+	ref := refs["service.api"][0]
+	require.Equal(t, "url", ref.Attribute)
+	require.Equal(t, hcl.Range{
+		Filename: "test.hcl",
+		Start:    hcl.Pos{Line: 12, Column: 10, Byte: 144},
+		End:      hcl.Pos{Line: 12, Column: 27, Byte: 161},
+	}, ref.Range)
+}
+
+func TestBodyReferences__JSON(t *testing.T) {
+	t.Parallel()
+	src := `{
+  "database": {
+    "main": {
+      "host": "${local.prefix}.db"
+    }
+  },
+  "service": {
+    "api": {
+      "url": "${database.main.url}",
+      "health_check": {
+        "path": "${var.health.path}"
+      }
+    }
+  }
+}`
+	file, diags := hcljson.Parse([]byte(src), "test.hcl.json")
+	diagsReport(t, diags)
+	refs, diags := hclutil.BodyReferences(file.Body, referencesSchema)
+	diagsReport(t, diags)
+	require.Equal(t, []string{"local.prefix"}, referenceStrings(refs["database.main"]))
+	require.Equal(t, []string{"database.main.url", "var.health.path"}, referenceStrings(refs["service.api"]))
+	require.Equal(t, "test.hcl.json", refs["service.api"][0].Range.Filename)
+}
+
+func TestBodyReferences__Override(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+service "api" {
+  url  = var.url
+  port = var.port
+  health {
+    path = var.health_path
+  }
+}
+`)},
+		"main_override.hcl": {Data: []byte(`
+service "api" {
+  url = database.main.url
+  health {
+    path = database.main.health_path
+  }
+  tls {
+    cert = var.cert
+  }
+}
+`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	refs, diags := hclutil.BodyReferences(body, referencesSchema)
+	diagsReport(t, diags)
+	require.Equal(t, []string{
+		"var.port",
+		"database.main.url",
+		"database.main.health_path",
+		"var.cert",
+	}, referenceStrings(refs["service.api"]))
+}
+
+func TestBodyReferences__OverrideRepeatedBlocks(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"main.hcl": {Data: []byte(`
+service "api" {
+  env {
+    a = var.a
+  }
+  env {
+    b = var.b
+  }
+}
+`)},
+		"main_override.hcl": {Data: []byte(`
+service "api" {
+  env {
+    a = var.c
+  }
+}
+`)},
+	}
+	body, _, diags := hclutil.ParseFS(testFs)
+	diagsReport(t, diags)
+	refs, diags := hclutil.BodyReferences(body, referencesSchema)
+	diagsReport(t, diags)
+	require.Equal(t, []string{"var.c", "var.b"}, referenceStrings(refs["service.api"]))
+}
+
+func TestValidateLocalReferences(t *testing.T) {
+	t.Parallel()
+	src := `
+locals {
+  prefix = "app"
+  name   = "${local.prefix}-${local.suffix}"
+}
+
+service "api" {
+  name = local.name
+  host = local.hostname
+}
+`
+	file, diags := hclsyntax.ParseConfig([]byte(src), "test.hcl", hcl.Pos{Line: 1, Column: 1})
+	diagsReport(t, diags)
+	diags = hclutil.ValidateLocalReferences(file.Body, &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "service", LabelNames: []string{"name"}},
+		},
+	})
+	require.Len(t, diags, 2)
+	require.Equal(t, "Reference to undeclared local value", diags[0].Summary)
+	require.Equal(t, `A local value with the name "suffix" has not been declared.`, diags[0].Detail)
+	require.Equal(t, 4, diags[0].Subject.Start.Line)
+	require.Equal(t, `A local value with the name "hostname" has not been declared.`, diags[1].Detail)
+	require.Equal(t, 9, diags[1].Subject.Start.Line)
+}
@@ -0,0 +1,419 @@
+package hclutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+const defaultTemplateMaxDepth = 10
+
+// MakeTemplateFileFunc は templatefile 関数を作成して返します。これは、指定されたパスのファイルを読み込んでテンプレートとして処理し、結果を返すHCLの関数です。
+// テンプレートの中からは templatefile を呼び出して他のテンプレートをインクルードできます。相対パスはインクルード元のテンプレートのディレクトリから解決されます。
+// HCL中での使用例としては以下となります。
+// ```
+// text = templatefile("path/to/file", {key = "value"})
+// ```
+// MakeTemplateFileFunc returns a function that creates the templatefile function. This is a HCL function that reads the file at the specified path, processes it as a template, and returns the result.
+// Templates can include other templates by calling templatefile. Relative paths are resolved from the directory of the including template.
+// An example of use in HCL is as follows.
+// ```
+// text = templatefile("path/to/file", {key = "value"})
+// ```
+func MakeTemplateFileFunc(functions map[string]function.Function, baseFSs ...fs.FS) function.Function {
+	return newTemplateRenderer(functions, &utilFunctionOptions{fsyss: baseFSs}).function(nil)
+}
+
+// RenderTemplate は HCLのテンプレートを評価して文字列を返します。
+// ctx が nil の場合は NewEvalContext で作成したEvalContextを使用します。vars はテンプレート中で変数として参照できます。
+//
+// RenderTemplate evaluates the HCL template src and returns the result as a string.
+// If ctx is nil, an EvalContext created by NewEvalContext is used. vars are accessible as variables in the template.
+func RenderTemplate(src []byte, filename string, vars map[string]cty.Value, ctx *hcl.EvalContext) (string, hcl.Diagnostics) {
+	if ctx == nil {
+		ctx = NewEvalContext()
+	}
+	expr, diags := hclsyntax.ParseTemplate(src, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	if vars == nil {
+		vars = map[string]cty.Value{}
+	}
+	evalCtx := ctx.NewChild()
+	evalCtx.Variables = vars
+	value, d := expr.Value(evalCtx)
+	diags = append(diags, d...)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	return templateResultString(filename, value, diags)
+}
+
+// RenderTemplateFile は fsys 上のテンプレートファイルを評価して文字列を返します。
+// 関数は NewEvalContext と同じものが使用でき、templatefile によるインクルードはテンプレートのディレクトリを基準に解決されます。
+//
+// RenderTemplateFile evaluates the template file at path in fsys and returns the result as a string.
+// The same functions as NewEvalContext are available, and templatefile includes are resolved relative to the template's directory.
+func RenderTemplateFile(fsys fs.FS, path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	opts := &utilFunctionOptions{fsyss: []fs.FS{fsys}}
+	r := newTemplateRenderer(utilFunctions(opts), opts)
+	src, err := openFile(path, fsys)
+	if err != nil {
+		return "", hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to open template file",
+			Detail:   err.Error(),
+		}}
+	}
+	value, diags := r.render(filepath.ToSlash(filepath.Clean(path)), src, vars, nil)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	return templateResultString(path, value, diags)
+}
+
+func templateResultString(filename string, value cty.Value, diags hcl.Diagnostics) (string, hcl.Diagnostics) {
+	value, _ = value.UnmarkDeep()
+	if !value.IsKnown() {
+		return "", diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid template result",
+			Detail:   fmt.Sprintf("The result of template %s is not known.", filename),
+		})
+	}
+	str, err := convert.Convert(value, cty.String)
+	if err != nil || str.IsNull() {
+		detail := fmt.Sprintf("The result of template %s must be a string.", filename)
+		if err != nil {
+			detail = fmt.Sprintf("The result of template %s must be a string: %s.", filename, err)
+		}
+		return "", diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid template result",
+			Detail:   detail,
+		})
+	}
+	return str.AsString(), diags
+}
+
+// MakeGoTemplateFileFunc は gotemplatefile 関数を作成して返します。これは、指定されたパスのファイルを Go の text/template として処理し、結果を返すHCLの関数です。
+// 変数は ConvertCTYValue で変換されてテンプレートに渡されます。また、functions に含まれる関数はテンプレート関数として呼び出せます。
+// ただし、text/template の組み込み関数と名前が重複するものや、式を引数に取る try, can は除外されます。
+// テンプレートから呼び出す templatefile と gotemplatefile は、テンプレートのディレクトリを基準にパスを解決し、インクルードの循環と深さの検出も引き継ぎます。
+// HCL中での使用例としては以下となります。
+// ```
+// text = gotemplatefile("path/to/file.tmpl", {key = "value"})
+// ```
+// MakeGoTemplateFileFunc returns the gotemplatefile function. This is a HCL function that reads the file at the specified path, processes it as a Go text/template, and returns the result.
+// Variables are converted by ConvertCTYValue before being passed to the template, and the functions are available as template funcs,
+// except those whose names collide with text/template builtins and try/can, which take expressions rather than values.
+// templatefile and gotemplatefile called from the template resolve relative paths from its directory and share the include cycle and depth checks.
+// An example of use in HCL is as follows.
+// ```
+// text = gotemplatefile("path/to/file.tmpl", {key = "value"})
+// ```
+func MakeGoTemplateFileFunc(functions map[string]function.Function, baseFSs ...fs.FS) function.Function {
+	return newTemplateRenderer(functions, &utilFunctionOptions{fsyss: baseFSs}).goFunction(nil)
+}
+
+// goTemplateExcludedFuncs は text/template のテンプレート関数として公開しない関数の一覧です。
+var goTemplateExcludedFuncs = map[string]bool{
+	// text/template builtins
+	"and": true, "call": true, "html": true, "index": true, "slice": true, "js": true, "len": true,
+	"not": true, "or": true, "print": true, "printf": true, "println": true, "urlquery": true,
+	"eq": true, "ge": true, "gt": true, "le": true, "lt": true, "ne": true,
+	// functions that take expressions instead of values
+	"try": true, "can": true,
+}
+
+func goTemplateFuncs(functions map[string]function.Function) template.FuncMap {
+	funcs := make(template.FuncMap, len(functions))
+	for name, f := range functions {
+		if goTemplateExcludedFuncs[name] {
+			continue
+		}
+		funcs[name] = goTemplateFunc(name, f)
+	}
+	return funcs
+}
+
+func goTemplateFunc(name string, f function.Function) func(args ...any) (any, error) {
+	params := f.Params()
+	varParam := f.VarParam()
+	return func(args ...any) (any, error) {
+		ctyArgs := make([]cty.Value, len(args))
+		for i, arg := range args {
+			v, err := goValueToCTYValue(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: argument %d: %w", name, i, err)
+			}
+			// HCLの関数呼び出しと同様に、引数をパラメータの型に変換します。
+			var param *function.Parameter
+			if i < len(params) {
+				param = &params[i]
+			} else {
+				param = varParam
+			}
+			if param != nil {
+				v, err = convert.Convert(v, param.Type)
+				if err != nil {
+					return nil, fmt.Errorf("%s: argument %d: %w", name, i, err)
+				}
+			}
+			ctyArgs[i] = v
+		}
+		ret, err := f.Call(ctyArgs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ret, _ = ret.UnmarkDeep()
+		return ConvertCTYValue(ret)
+	}
+}
+
+// goValueToCTYValue は ConvertCTYValue で変換された値を cty.Value に戻します。
+func goValueToCTYValue(v any) (cty.Value, error) {
+	switch v := v.(type) {
+	case cty.Value:
+		return v, nil
+	case *big.Float:
+		if v == nil {
+			return cty.NullVal(cty.Number), nil
+		}
+		return cty.NumberVal(v), nil
+	case []any:
+		if len(v) == 0 {
+			return cty.EmptyTupleVal, nil
+		}
+		elems := make([]cty.Value, len(v))
+		for i, e := range v {
+			ev, err := goValueToCTYValue(e)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			elems[i] = ev
+		}
+		return cty.TupleVal(elems), nil
+	case map[string]any:
+		if len(v) == 0 {
+			return cty.EmptyObjectVal, nil
+		}
+		attrs := make(map[string]cty.Value, len(v))
+		for k, e := range v {
+			ev, err := goValueToCTYValue(e)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			attrs[k] = ev
+		}
+		return cty.ObjectVal(attrs), nil
+	default:
+		return MarshalCTYValue(v)
+	}
+}
+
+// templateRenderer は templatefile 関数の実体です。パース済みのテンプレートをファイルの内容のハッシュと共にキャッシュします。
+type templateRenderer struct {
+	functions   map[string]function.Function
+	baseFSs     []fs.FS
+	maxDepth    int
+	diagsWriter *DiagnosticsWriter
+	cache       sync.Map
+}
+
+type cachedTemplate struct {
+	sum  [sha256.Size]byte
+	expr hclsyntax.Expression
+}
+
+func newTemplateRenderer(functions map[string]function.Function, opts *utilFunctionOptions) *templateRenderer {
+	maxDepth := opts.templateMaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultTemplateMaxDepth
+	}
+	return &templateRenderer{
+		functions:   functions,
+		baseFSs:     opts.fsyss,
+		maxDepth:    maxDepth,
+		diagsWriter: opts.diagsWriter,
+	}
+}
+
+// function は chain をインクルード元とする templatefile 関数を返します。
+func (r *templateRenderer) function(chain []string) function.Function {
+	render := func(args []cty.Value) (cty.Value, error) {
+		if len(args) != 2 {
+			return cty.UnknownVal(cty.DynamicPseudoType), errors.New("require argument length 2")
+		}
+		if ty := args[1].Type(); !ty.IsObjectType() && !ty.IsMapType() {
+			return cty.UnknownVal(cty.DynamicPseudoType), errors.New("require second argument is map or object type")
+		}
+		pathArg, pathMarks := args[0].Unmark()
+		targetFile := r.resolve(pathArg.AsString(), chain)
+		if err := r.checkChain(targetFile, chain); err != nil {
+			return cty.UnknownVal(cty.DynamicPseudoType), function.NewArgError(0, err)
+		}
+		src, err := openFile(targetFile, r.baseFSs...)
+		if err != nil {
+			return cty.UnknownVal(cty.DynamicPseudoType), function.NewArgError(0, err)
+		}
+		value, diags := r.render(targetFile, src, args[1].AsValueMap(), chain)
+		if diags.HasErrors() {
+			return cty.UnknownVal(cty.DynamicPseudoType), diags
+		}
+		return value.WithMarks(pathMarks), nil
+	}
+
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name:        "path",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name: "variables",
+				Type: cty.DynamicPseudoType,
+			},
+		},
+		// 結果の型はテンプレートを評価するまで決まらないが、型の確認のためだけに評価するとインクルードのたびに評価が倍増するため、評価は Impl でだけ行う
+		Type: function.StaticReturnType(cty.DynamicPseudoType),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return render(args)
+		},
+	})
+}
+
+// goFunction は chain をインクルード元とする gotemplatefile 関数を返します。
+// テンプレート関数の templatefile と gotemplatefile も chain を引き継ぐため、HCLのテンプレートとGoのテンプレートが相互にインクルードしても循環と深さを検出できます。
+func (r *templateRenderer) goFunction(chain []string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name:        "path",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name: "variables",
+				Type: cty.DynamicPseudoType,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			if ty := args[1].Type(); !ty.IsObjectType() && !ty.IsMapType() {
+				return cty.UnknownVal(cty.String), errors.New("require second argument is map or object type")
+			}
+			pathArg, pathMarks := args[0].Unmark()
+			targetFile := r.resolve(pathArg.AsString(), chain)
+			if err := r.checkChain(targetFile, chain); err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			src, err := openFile(targetFile, r.baseFSs...)
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			if r.diagsWriter != nil {
+				r.diagsWriter.AddSource(targetFile, src)
+			}
+			data, err := ConvertCTYValue(args[1])
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(1, err)
+			}
+			funcs := goTemplateFuncs(r.functions)
+			nextChain := make([]string, len(chain), len(chain)+1)
+			copy(nextChain, chain)
+			nextChain = append(nextChain, targetFile)
+			funcs["templatefile"] = goTemplateFunc("templatefile", r.function(nextChain))
+			funcs["gotemplatefile"] = goTemplateFunc("gotemplatefile", r.goFunction(nextChain))
+			tmpl, err := template.New(targetFile).Funcs(funcs).Parse(string(src))
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			return cty.StringVal(buf.String()).WithMarks(pathMarks), nil
+		},
+	})
+}
+
+// resolve は インクルード元のテンプレートのディレクトリを基準にパスを解決します。
+func (r *templateRenderer) resolve(target string, chain []string) string {
+	if len(chain) == 0 || path.IsAbs(target) {
+		return path.Clean(target)
+	}
+	return path.Join(path.Dir(chain[len(chain)-1]), target)
+}
+
+func (r *templateRenderer) checkChain(target string, chain []string) error {
+	for _, c := range chain {
+		if c == target {
+			return fmt.Errorf("template include cycle detected: %s", strings.Join(append(chain, target), " -> "))
+		}
+	}
+	if len(chain) >= r.maxDepth {
+		return fmt.Errorf("template include depth exceeds the limit of %d: %s", r.maxDepth, strings.Join(append(chain, target), " -> "))
+	}
+	return nil
+}
+
+func (r *templateRenderer) parse(targetFile string, src []byte) (hclsyntax.Expression, hcl.Diagnostics) {
+	sum := sha256.Sum256(src)
+	if v, ok := r.cache.Load(targetFile); ok {
+		if cached := v.(*cachedTemplate); cached.sum == sum {
+			return cached.expr, nil
+		}
+	}
+	expr, diags := hclsyntax.ParseTemplate(src, targetFile, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	r.cache.Store(targetFile, &cachedTemplate{sum: sum, expr: expr})
+	return expr, diags
+}
+
+func (r *templateRenderer) render(targetFile string, src []byte, variables map[string]cty.Value, chain []string) (cty.Value, hcl.Diagnostics) {
+	if r.diagsWriter != nil {
+		r.diagsWriter.AddSource(targetFile, src)
+	}
+	expr, diags := r.parse(targetFile, src)
+	if diags.HasErrors() {
+		return cty.UnknownVal(cty.DynamicPseudoType), diags
+	}
+	nextChain := make([]string, len(chain), len(chain)+1)
+	copy(nextChain, chain)
+	nextChain = append(nextChain, targetFile)
+	functions := make(map[string]function.Function, len(r.functions))
+	for name, f := range r.functions {
+		functions[name] = f
+	}
+	functions["templatefile"] = r.function(nextChain)
+	functions["gotemplatefile"] = r.goFunction(nextChain)
+	if variables == nil {
+		variables = map[string]cty.Value{}
+	}
+	ctx := &hcl.EvalContext{
+		Variables: variables,
+		Functions: functions,
+	}
+	value, d := expr.Value(ctx)
+	diags = append(diags, d...)
+	return value, diags
+}
@@ -0,0 +1,236 @@
+package hclutil_test
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mashiike/hclutil"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestHCLFunctionTemplateFile__Include(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"templates/main.tmpl": {
+			Data: []byte(`header: ${templatefile("partials/header.tmpl", { name = name })}`),
+		},
+		"templates/partials/header.tmpl": {
+			Data: []byte(`hello ${name}${templatefile("../footer.tmpl", {})}`),
+		},
+		"templates/footer.tmpl": {
+			Data: []byte(`!`),
+		},
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(`templatefile("templates/main.tmpl", { name = "hoge" })`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	var str string
+	diags = gohcl.DecodeExpression(expr, hclutil.NewEvalContext(hclutil.WithFS(testFs)), &str)
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("decode failed")
+	}
+	if want := "header: hello hoge!"; str != want {
+		t.Errorf("want %q, got %q", want, str)
+	}
+}
+
+func TestHCLFunctionTemplateFile__Cycle(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"a.tmpl": {
+			Data: []byte(`${templatefile("b.tmpl", {})}`),
+		},
+		"b.tmpl": {
+			Data: []byte(`${templatefile("a.tmpl", {})}`),
+		},
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(`templatefile("a.tmpl", {})`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	_, diags = expr.Value(hclutil.NewEvalContext(hclutil.WithFS(testFs)))
+	if !diags.HasErrors() {
+		t.Fatal("expected error, got none")
+	}
+	if !strings.Contains(diags.Error(), "template include cycle detected: a.tmpl -> b.tmpl -> a.tmpl") {
+		t.Errorf("unexpected error: %s", diags.Error())
+	}
+}
+
+func TestHCLFunctionTemplateFile__MaxDepth(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"a.tmpl": {
+			Data: []byte(`${templatefile("b.tmpl", {})}`),
+		},
+		"b.tmpl": {
+			Data: []byte(`${templatefile("c.tmpl", {})}`),
+		},
+		"c.tmpl": {
+			Data: []byte(`c`),
+		},
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(`templatefile("a.tmpl", {})`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	_, diags = expr.Value(hclutil.NewEvalContext(hclutil.WithFS(testFs), hclutil.WithTemplateMaxDepth(2)))
+	if !diags.HasErrors() {
+		t.Fatal("expected error, got none")
+	}
+	if !strings.Contains(diags.Error(), "template include depth exceeds the limit of 2: a.tmpl -> b.tmpl -> c.tmpl") {
+		t.Errorf("unexpected error: %s", diags.Error())
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	t.Parallel()
+	str, diags := hclutil.RenderTemplate([]byte(`hello ${upper(name)}`), "notification.tmpl", map[string]cty.Value{
+		"name": cty.StringVal("hoge"),
+	}, nil)
+	diagsReport(t, diags)
+	if want := "hello HOGE"; str != want {
+		t.Errorf("want %q, got %q", want, str)
+	}
+}
+
+func TestRenderTemplate__Error(t *testing.T) {
+	t.Parallel()
+	_, diags := hclutil.RenderTemplate([]byte(`hello ${undefined}`), "notification.tmpl", nil, nil)
+	if !diags.HasErrors() {
+		t.Fatal("expected error, got none")
+	}
+	if !strings.Contains(diags.Error(), "notification.tmpl:1,9-18: Unknown variable") {
+		t.Errorf("unexpected error: %s", diags.Error())
+	}
+}
+
+func TestRenderTemplateFile(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"templates/body.tmpl": {
+			Data: []byte(`%{ for item in items }${templatefile("item.tmpl", { item = item })}%{ endfor }`),
+		},
+		"templates/item.tmpl": {
+			Data: []byte("- ${item}\n"),
+		},
+	}
+	str, diags := hclutil.RenderTemplateFile(testFs, "templates/body.tmpl", map[string]cty.Value{
+		"items": cty.ListVal([]cty.Value{cty.StringVal("hoge"), cty.StringVal("fuga")}),
+	})
+	diagsReport(t, diags)
+	if want := "- hoge\n- fuga\n"; str != want {
+		t.Errorf("want %q, got %q", want, str)
+	}
+}
+
+func TestHCLFunctionGoTemplateFile(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"body.gotmpl": {
+			Data: []byte(`hello {{ .name | upper }}, count={{ add .count 1 }}, items={{ join "," .items }}{{ if .enabled }}!{{ end }}`),
+		},
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(`gotemplatefile("body.gotmpl", { name = "hoge", count = 2, items = ["a", "b"], enabled = true })`), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("parse failed")
+	}
+	var str string
+	diags = gohcl.DecodeExpression(expr, hclutil.NewEvalContext(hclutil.WithFS(testFs)), &str)
+	if diags.HasErrors() {
+		t.Log(diags)
+		t.Fatal("decode failed")
+	}
+	if want := "hello HOGE, count=3, items=a,b!"; str != want {
+		t.Errorf("want %q, got %q", want, str)
+	}
+}
+
+type countingFS struct {
+	fstest.MapFS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (fsys *countingFS) Open(name string) (fs.File, error) {
+	fsys.mu.Lock()
+	fsys.opens[name]++
+	fsys.mu.Unlock()
+	return fsys.MapFS.Open(name)
+}
+
+func TestHCLFunctionTemplateFile__IncludeChainRendersOnce(t *testing.T) {
+	t.Parallel()
+	testFs := &countingFS{MapFS: fstest.MapFS{}, opens: map[string]int{}}
+	for i := 0; i < 8; i++ {
+		testFs.MapFS[fmt.Sprintf("t%d.tmpl", i)] = &fstest.MapFile{
+			Data: []byte(fmt.Sprintf(`%d${templatefile("t%d.tmpl", {})}`, i, i+1)),
+		}
+	}
+	testFs.MapFS["t8.tmpl"] = &fstest.MapFile{Data: []byte(`8`)}
+	str, diags := hclutil.RenderTemplateFile(testFs, "t0.tmpl", nil)
+	diagsReport(t, diags)
+	if want := "012345678"; str != want {
+		t.Errorf("want %q, got %q", want, str)
+	}
+	for name, n := range testFs.opens {
+		if n != 1 {
+			t.Errorf("%s opened %d times, want 1", name, n)
+		}
+	}
+}
+
+func TestHCLFunctionGoTemplateFile__Cycle(t *testing.T) {
+	t.Parallel()
+	testFs := fstest.MapFS{
+		"a.tmpl": {
+			Data: []byte(`${gotemplatefile("b.gotmpl", {})}`),
+		},
+		"b.gotmpl": {
+			Data: []byte(`{{ templatefile "a.tmpl" . }}`),
+		},
+		"self.gotmpl": {
+			Data: []byte(`{{ gotemplatefile "self.gotmpl" . }}`),
+		},
+	}
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{
+			expr: `templatefile("a.tmpl", {})`,
+			want: "template include cycle detected: a.tmpl -> b.gotmpl -> a.tmpl",
+		},
+		{
+			expr: `gotemplatefile("self.gotmpl", {})`,
+			want: "template include cycle detected: self.gotmpl -> self.gotmpl",
+		},
+	}
+	for _, c := range cases {
+		expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			t.Log(diags)
+			t.Fatal("parse failed")
+		}
+		_, diags = expr.Value(hclutil.NewEvalContext(hclutil.WithFS(testFs)))
+		if !diags.HasErrors() {
+			t.Fatalf("%s: expected error, got none", c.expr)
+		}
+		if !strings.Contains(diags.Error(), c.want) {
+			t.Errorf("%s: unexpected error: %s", c.expr, diags.Error())
+		}
+	}
+}
@@ -2,11 +2,19 @@ package hclutil
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
 )
 
+// TraversalToString は トラバーサルを "a.b[0][\"key\"]" のような文字列にします。
+// 相対トラバーサルは ".b[0]" のように "." から始まり、スプラットは "a[*].b" のようになります。ParseTraversal で元に戻せます。
+//
+// TraversalToString renders the traversal as a string such as "a.b[0][\"key\"]".
+// Relative traversals start with "." as in ".b[0]", and splats are rendered as in "a[*].b". ParseTraversal converts it back.
 func TraversalToString(t hcl.Traversal) string {
 	parts := make([]string, 0, len(t))
 	if t.IsRelative() {
@@ -37,6 +45,11 @@ func traverserToString(parts []string, tr hcl.Traverser) []string {
 	case hcl.TraverseRoot:
 		parts = append(parts, tr.Name)
 	case hcl.TraverseSplat:
+		if len(parts) > 0 && parts[len(parts)-1] != "" {
+			parts[len(parts)-1] += "[*]"
+		} else {
+			parts = append(parts, "[*]")
+		}
 		for _, tt := range tr.Each {
 			parts = traverserToString(parts, tt)
 		}
@@ -53,3 +66,213 @@ func VariablesReffarances(expr hcl.Expression) []string {
 	}
 	return vars
 }
+
+const traversalFilename = "<traversal>"
+
+// ParseTraversal は TraversalToString が返す形式の文字列をトラバーサルに戻します。
+// "." から始まる場合は相対トラバーサルになります。インデックスは "[0]" や "[\"key\"]" のようにJSONで書き、"[*]" はそれ以降を対象にするスプラットになります。
+//
+// ParseTraversal converts a string in the format returned by TraversalToString back into a traversal.
+// A string starting with "." is parsed as a relative traversal. Indexes are written in JSON as in "[0]" or "[\"key\"]",
+// and "[*]" is a splat applied to the rest of the traversal.
+//
+//	traversal, diags := hclutil.ParseTraversal(`servers[0].tags["app"]`)
+func ParseTraversal(s string) (hcl.Traversal, hcl.Diagnostics) {
+	p := &traversalParser{src: s}
+	return p.parse()
+}
+
+type traversalParser struct {
+	src string
+	pos int
+}
+
+func (p *traversalParser) parse() (hcl.Traversal, hcl.Diagnostics) {
+	var traversal hcl.Traversal
+	switch {
+	case strings.HasPrefix(p.src, "."):
+		p.pos++
+		if p.pos < len(p.src) && p.src[p.pos] != '[' {
+			name, diags := p.name()
+			if diags.HasErrors() {
+				return nil, diags
+			}
+			traversal = append(traversal, hcl.TraverseAttr{Name: name, SrcRange: p.rangeFrom(p.pos - len(name))})
+		}
+	case strings.HasPrefix(p.src, "["):
+		// "[0]" のようにインデックスから始まる相対トラバーサル
+	default:
+		name, diags := p.name()
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		traversal = append(traversal, hcl.TraverseRoot{Name: name, SrcRange: p.rangeFrom(0)})
+	}
+	rest, diags := p.steps()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	traversal = append(traversal, rest...)
+	if len(traversal) == 0 {
+		return nil, p.error(p.pos, "A traversal must have at least one step.")
+	}
+	return traversal, nil
+}
+
+func (p *traversalParser) steps() (hcl.Traversal, hcl.Diagnostics) {
+	var traversal hcl.Traversal
+	for p.pos < len(p.src) {
+		start := p.pos
+		switch p.src[p.pos] {
+		case '.':
+			p.pos++
+			name, diags := p.name()
+			if diags.HasErrors() {
+				return nil, diags
+			}
+			traversal = append(traversal, hcl.TraverseAttr{Name: name, SrcRange: p.rangeFrom(start)})
+		case '[':
+			if strings.HasPrefix(p.src[p.pos:], "[*]") {
+				p.pos += len("[*]")
+				splat := hcl.TraverseSplat{SrcRange: p.rangeFrom(start)}
+				each, diags := p.steps()
+				if diags.HasErrors() {
+					return nil, diags
+				}
+				splat.Each = each
+				return append(traversal, splat), nil
+			}
+			key, diags := p.index()
+			if diags.HasErrors() {
+				return nil, diags
+			}
+			traversal = append(traversal, hcl.TraverseIndex{Key: key, SrcRange: p.rangeFrom(start)})
+		default:
+			return nil, p.error(p.pos, `Expected "." or "[".`)
+		}
+	}
+	return traversal, nil
+}
+
+func (p *traversalParser) name() (string, hcl.Diagnostics) {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != '.' && p.src[p.pos] != '[' {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	if !hclsyntax.ValidIdentifier(name) {
+		return "", p.error(start, fmt.Sprintf("%q is not a valid name.", name))
+	}
+	return name, nil
+}
+
+func (p *traversalParser) index() (cty.Value, hcl.Diagnostics) {
+	start := p.pos
+	p.pos++
+	dec := json.NewDecoder(strings.NewReader(p.src[p.pos:]))
+	dec.UseNumber()
+	var key interface{}
+	if err := dec.Decode(&key); err != nil {
+		return cty.NilVal, p.error(start, "An index must be a JSON string, number or boolean.")
+	}
+	p.pos += int(dec.InputOffset())
+	if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+		return cty.NilVal, p.error(start, `Missing "]" after the index.`)
+	}
+	p.pos++
+	switch key := key.(type) {
+	case string:
+		return cty.StringVal(key), nil
+	case bool:
+		return cty.BoolVal(key), nil
+	case json.Number:
+		v, err := cty.ParseNumberVal(key.String())
+		if err != nil {
+			return cty.NilVal, p.error(start, err.Error())
+		}
+		return v, nil
+	}
+	return cty.NilVal, p.error(start, "An index must be a JSON string, number or boolean.")
+}
+
+func (p *traversalParser) rangeFrom(start int) hcl.Range {
+	return hcl.Range{
+		Filename: traversalFilename,
+		Start:    hcl.Pos{Line: 1, Column: start + 1, Byte: start},
+		End:      hcl.Pos{Line: 1, Column: p.pos + 1, Byte: p.pos},
+	}
+}
+
+func (p *traversalParser) error(start int, detail string) hcl.Diagnostics {
+	end := start + 1
+	if end > len(p.src) {
+		end = len(p.src)
+	}
+	return hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid traversal",
+		Detail:   detail,
+		Subject: &hcl.Range{
+			Filename: traversalFilename,
+			Start:    hcl.Pos{Line: 1, Column: start + 1, Byte: start},
+			End:      hcl.Pos{Line: 1, Column: end + 1, Byte: end},
+		},
+	}}
+}
+
+// ApplyTraversal は value に traversal を適用した値を返します。
+// 絶対トラバーサルの場合、先頭の名前は value の属性として扱われるため、value には変数のオブジェクトを渡せます。
+// スプラットは value の各要素に残りのトラバーサルを適用したタプルになります。
+//
+// ApplyTraversal returns the value obtained by applying traversal to value.
+// For an absolute traversal the root name is looked up as an attribute of value, so value can be an object of variables.
+// A splat applies the rest of the traversal to each element of the value and results in a tuple.
+//
+//	v, diags := hclutil.ApplyTraversal(vars, traversal)
+func ApplyTraversal(value cty.Value, traversal hcl.Traversal) (cty.Value, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	for _, tr := range traversal {
+		var d hcl.Diagnostics
+		switch tr := tr.(type) {
+		case hcl.TraverseRoot:
+			value, d = hcl.TraverseAttr{Name: tr.Name, SrcRange: tr.SrcRange}.TraversalStep(value)
+		case hcl.TraverseSplat:
+			value, d = applySplat(value, tr)
+		default:
+			value, d = tr.TraversalStep(value)
+		}
+		diags = diags.Extend(d)
+		if d.HasErrors() {
+			return cty.DynamicVal, diags
+		}
+	}
+	return value, diags
+}
+
+func applySplat(value cty.Value, splat hcl.TraverseSplat) (cty.Value, hcl.Diagnostics) {
+	if !value.IsKnown() {
+		return cty.DynamicVal, nil
+	}
+	if value.IsNull() {
+		return cty.EmptyTupleVal, nil
+	}
+	value, marks := value.Unmark()
+	var elems []cty.Value
+	if ty := value.Type(); ty.IsListType() || ty.IsSetType() || ty.IsTupleType() {
+		elems = value.AsValueSlice()
+	} else {
+		// コレクションでない値は、その値だけを要素とするタプルとして扱う
+		elems = []cty.Value{value}
+	}
+	var diags hcl.Diagnostics
+	results := make([]cty.Value, len(elems))
+	for i, elem := range elems {
+		v, d := ApplyTraversal(elem, splat.Each)
+		diags = diags.Extend(d)
+		results[i] = v
+	}
+	if diags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+	return cty.TupleVal(results).WithMarks(marks), diags
+}
@@ -3,7 +3,10 @@ package hclutil_test
 import (
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestVariablesReffarances(t *testing.T) {
@@ -35,3 +38,119 @@ func TestVariablesReffarances(t *testing.T) {
 		t.Errorf("unexpected variable: %s", vars[3])
 	}
 }
+
+func TestParseTraversal(t *testing.T) {
+	cases := []struct {
+		name      string
+		traversal hcl.Traversal
+		str       string
+	}{
+		{
+			name: "attribute",
+			traversal: hcl.Traversal{
+				hcl.TraverseRoot{Name: "hoge"},
+				hcl.TraverseAttr{Name: "fuga"},
+			},
+			str: "hoge.fuga",
+		},
+		{
+			name: "index",
+			traversal: hcl.Traversal{
+				hcl.TraverseRoot{Name: "hoge"},
+				hcl.TraverseAttr{Name: "fuga"},
+				hcl.TraverseIndex{Key: cty.NumberIntVal(0)},
+				hcl.TraverseIndex{Key: cty.StringVal("app.kubernetes.io/name")},
+				hcl.TraverseAttr{Name: "piyo"},
+			},
+			str: `hoge.fuga[0]["app.kubernetes.io/name"].piyo`,
+		},
+		{
+			name: "relative",
+			traversal: hcl.Traversal{
+				hcl.TraverseAttr{Name: "fuga"},
+				hcl.TraverseIndex{Key: cty.NumberIntVal(1)},
+			},
+			str: ".fuga[1]",
+		},
+		{
+			name: "relative_index",
+			traversal: hcl.Traversal{
+				hcl.TraverseIndex{Key: cty.StringVal("key")},
+				hcl.TraverseAttr{Name: "fuga"},
+			},
+			str: `.["key"].fuga`,
+		},
+		{
+			name: "splat",
+			traversal: hcl.Traversal{
+				hcl.TraverseRoot{Name: "servers"},
+				hcl.TraverseSplat{Each: hcl.Traversal{
+					hcl.TraverseAttr{Name: "tags"},
+					hcl.TraverseIndex{Key: cty.StringVal("name")},
+				}},
+			},
+			str: `servers[*].tags["name"]`,
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			str := hclutil.TraversalToString(c.traversal)
+			require.Equal(t, c.str, str)
+			traversal, diags := hclutil.ParseTraversal(str)
+			diagsReport(t, diags)
+			require.Equal(t, str, hclutil.TraversalToString(traversal))
+			require.Equal(t, c.traversal.IsRelative(), traversal.IsRelative())
+			require.Len(t, traversal, len(c.traversal))
+		})
+	}
+}
+
+func TestParseTraversal__Invalid(t *testing.T) {
+	for _, str := range []string{"", ".", "hoge.", "hoge..fuga", "hoge[0", "hoge[?]", "hoge fuga", "hoge[0]fuga"} {
+		_, diags := hclutil.ParseTraversal(str)
+		require.True(t, diags.HasErrors(), "expected error for %q", str)
+		require.Equal(t, "Invalid traversal", diags[0].Summary)
+	}
+}
+
+func TestApplyTraversal(t *testing.T) {
+	value := cty.ObjectVal(map[string]cty.Value{
+		"servers": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{
+				"ip":   cty.StringVal("10.0.0.1"),
+				"tags": cty.MapVal(map[string]cty.Value{"name": cty.StringVal("web")}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"ip":   cty.StringVal("10.0.0.2"),
+				"tags": cty.MapVal(map[string]cty.Value{"name": cty.StringVal("db")}),
+			}),
+		}),
+	})
+	cases := []struct {
+		str      string
+		expected cty.Value
+	}{
+		{str: "servers[1].ip", expected: cty.StringVal("10.0.0.2")},
+		{str: `servers[0].tags["name"]`, expected: cty.StringVal("web")},
+		{str: "servers[*].ip", expected: cty.TupleVal([]cty.Value{cty.StringVal("10.0.0.1"), cty.StringVal("10.0.0.2")})},
+	}
+	for _, c := range cases {
+		traversal, diags := hclutil.ParseTraversal(c.str)
+		diagsReport(t, diags)
+		v, diags := hclutil.ApplyTraversal(value, traversal)
+		diagsReport(t, diags)
+		require.True(t, c.expected.RawEquals(v), "%s: %#v", c.str, v)
+	}
+
+	traversal, diags := hclutil.ParseTraversal(".ip")
+	diagsReport(t, diags)
+	v, diags := hclutil.ApplyTraversal(value.GetAttr("servers").Index(cty.NumberIntVal(0)), traversal)
+	diagsReport(t, diags)
+	require.Equal(t, cty.StringVal("10.0.0.1"), v)
+
+	traversal, diags = hclutil.ParseTraversal("servers[2].ip")
+	diagsReport(t, diags)
+	_, diags = hclutil.ApplyTraversal(value, traversal)
+	require.True(t, diags.HasErrors())
+}
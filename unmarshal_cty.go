@@ -20,6 +20,9 @@ func UnmarshalCTYValue(value cty.Value, v any) error {
 }
 
 func unmarshalCTYValue(path string, value cty.Value, rv reflect.Value) error {
+	if !value.IsKnown() {
+		return &UnknownValueError{Value: value, Path: path}
+	}
 	t := value.Type()
 	switch {
 	case t.IsListType() || t.IsTupleType() || t.IsSetType():
@@ -40,7 +43,7 @@ func unmarshalCTYValue(path string, value cty.Value, rv reflect.Value) error {
 				return err
 			}
 		}
-	case t == cty.NilType:
+	case t == cty.NilType, t == cty.DynamicPseudoType && value.IsNull():
 		if rv.IsValid() {
 			if err := unmarshalCTYNil(path, value, rv); err != nil {
 				return err
@@ -198,37 +201,49 @@ func unmarshalCTYPrimitive(path string, value cty.Value, rv reflect.Value) error
 		}
 		return unmarshalCTYPrimitive(path, value, pv.Elem())
 	}
+	// null は型を検査した後にゼロ値にする。型の合わない null はエラーのまま
+	null := value.IsNull()
 	switch pv.Kind() {
 	case reflect.Bool:
 		if value.Type() != cty.Bool {
 			return &UnmarshalTypeError{CTYType: value.Type(), Type: pv.Type()}
 		}
-		pv.SetBool(value.True())
+		if !null {
+			pv.SetBool(value.True())
+		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if value.Type() != cty.Number {
 			return &UnmarshalTypeError{CTYType: value.Type(), Type: pv.Type()}
 		}
-		num, _ := value.AsBigFloat().Int64()
-		pv.SetInt(num)
+		if !null {
+			num, _ := value.AsBigFloat().Int64()
+			pv.SetInt(num)
+		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		if value.Type() != cty.Number {
 			return &UnmarshalTypeError{CTYType: value.Type(), Type: pv.Type()}
 		}
-		num, _ := value.AsBigFloat().Uint64()
-		pv.SetUint(num)
+		if !null {
+			num, _ := value.AsBigFloat().Uint64()
+			pv.SetUint(num)
+		}
 	case reflect.Float32, reflect.Float64:
 		if value.Type() != cty.Number {
 			return &UnmarshalTypeError{CTYType: value.Type(), Type: pv.Type()}
 		}
-		num, _ := value.AsBigFloat().Float64()
-		pv.SetFloat(num)
+		if !null {
+			num, _ := value.AsBigFloat().Float64()
+			pv.SetFloat(num)
+		}
 	case reflect.String:
 		if value.Type() != cty.String {
 			return &UnmarshalTypeError{CTYType: value.Type(), Type: pv.Type()}
 		}
-		pv.SetString(value.AsString())
+		if !null {
+			pv.SetString(value.AsString())
+		}
 	case reflect.Interface:
-		if pv.NumMethod() == 0 {
+		if pv.NumMethod() == 0 && !null {
 			converted, err := convertCTYValue(value)
 			if err != nil {
 				return err
@@ -239,6 +254,9 @@ func unmarshalCTYPrimitive(path string, value cty.Value, rv reflect.Value) error
 	default:
 		return &UnmarshalTypeError{CTYType: value.Type(), Type: pv.Type(), Path: path}
 	}
+	if null {
+		pv.Set(reflect.Zero(pv.Type()))
+	}
 	return nil
 }
 
@@ -349,11 +367,16 @@ type CTYValueUnmarshaler interface {
 	UnmarshalCTYValue(cty.Value) error
 }
 
+// UnknownValueError describes an unknown value passed to UnmarshalCTYValue.
 type UnknownValueError struct {
 	Value cty.Value
+	Path  string
 }
 
 func (e *UnknownValueError) Error() string {
+	if e.Path != "" {
+		return "hclutil: unknown value " + e.Value.GoString() + " [" + e.Path + "]"
+	}
 	return "hclutil: unknown value " + e.Value.GoString()
 }
 
@@ -150,6 +150,51 @@ func TestUnmarshalCTYValue__PrimitiveType(t *testing.T) {
 	})
 }
 
+func TestUnmarshalCTYValue__NullPrimitive(t *testing.T) {
+	t.Parallel()
+	t.Run("cty.NullVal(cty.String) to string", func(t *testing.T) {
+		t.Parallel()
+		v := "hoge"
+		err := hclutil.UnmarshalCTYValue(cty.NullVal(cty.String), &v)
+		if err != nil {
+			t.Error(err)
+		}
+		if v != "" {
+			t.Errorf("v = %s, want \"\"", v)
+		}
+	})
+	t.Run("cty.NullVal(cty.Number) to int", func(t *testing.T) {
+		t.Parallel()
+		v := 1
+		err := hclutil.UnmarshalCTYValue(cty.NullVal(cty.Number), &v)
+		if err != nil {
+			t.Error(err)
+		}
+		if v != 0 {
+			t.Errorf("v = %d, want 0", v)
+		}
+	})
+	t.Run("cty.NullVal(cty.String) to int", func(t *testing.T) {
+		t.Parallel()
+		v := 1
+		err := hclutil.UnmarshalCTYValue(cty.NullVal(cty.String), &v)
+		if err == nil {
+			t.Error("expected error")
+		}
+		if v != 1 {
+			t.Errorf("v = %d, want 1", v)
+		}
+	})
+	t.Run("cty.NullVal(cty.Bool) to string", func(t *testing.T) {
+		t.Parallel()
+		var v string
+		err := hclutil.UnmarshalCTYValue(cty.NullVal(cty.Bool), &v)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
 func TestUnmarshalCTYValue__PtrPrimitive(t *testing.T) {
 	t.Parallel()
 	t.Run("string", func(t *testing.T) {
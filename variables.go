@@ -1,37 +1,247 @@
 package hclutil
 
-import "github.com/zclconf/go-cty/cty"
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
 
 // MergeVariables merges multiple variables into one.
+// Objects are merged deeply, and other values are replaced by later ones.
 func MergeVariables(vars ...map[string]cty.Value) map[string]cty.Value {
+	// デフォルトの設定では衝突はエラーにならない
+	result, _ := MergeVariablesWithOptions(vars)
+	return result
+}
+
+// ConflictPolicy は MergeVariablesWithOptions で値がマージできずに衝突したときの扱いです。
+// ConflictPolicy is how MergeVariablesWithOptions handles values that collide and cannot be merged.
+type ConflictPolicy int
+
+const (
+	// ConflictLastWins は 後の値を採用します。デフォルトです。
+	// ConflictLastWins takes the later value. This is the default.
+	ConflictLastWins ConflictPolicy = iota
+	// ConflictFirstWins は 先の値を採用します。
+	// ConflictFirstWins keeps the earlier value.
+	ConflictFirstWins
+	// ConflictError は *MergeConflictError を返します。
+	// ConflictError returns a *MergeConflictError.
+	ConflictError
+)
+
+// ListMergeStrategy は リストとタプルのマージ方法です。
+// ListMergeStrategy is how lists and tuples are merged.
+type ListMergeStrategy int
+
+const (
+	// ListReplace は 後のリストで置き換えます。デフォルトです。
+	// ListReplace replaces the list with the later one. This is the default.
+	ListReplace ListMergeStrategy = iota
+	// ListAppend は 後のリストの要素を末尾に追加します。
+	// ListAppend appends the elements of the later list.
+	ListAppend
+)
+
+type mergeVariablesOptions struct {
+	mergeMaps bool
+	lists     ListMergeStrategy
+	setUnion  bool
+	conflict  ConflictPolicy
+}
+
+// WithMapMerge は cty.Map の値をオブジェクトと同じように再帰的にマージします。
+// WithMapMerge merges cty.Map values recursively, like objects.
+func WithMapMerge() func(*mergeVariablesOptions) {
+	return func(opts *mergeVariablesOptions) {
+		opts.mergeMaps = true
+	}
+}
+
+// WithListMergeStrategy は リストとタプルのマージ方法を指定します。
+// WithListMergeStrategy sets how lists and tuples are merged.
+func WithListMergeStrategy(strategy ListMergeStrategy) func(*mergeVariablesOptions) {
+	return func(opts *mergeVariablesOptions) {
+		opts.lists = strategy
+	}
+}
+
+// WithSetUnion は 同じ要素型の cty.Set の値を和集合にします。
+// WithSetUnion merges cty.Set values of the same element type into their union.
+func WithSetUnion() func(*mergeVariablesOptions) {
+	return func(opts *mergeVariablesOptions) {
+		opts.setUnion = true
+	}
+}
+
+// WithConflictPolicy は マージできない値が衝突したときの扱いを指定します。
+// 型の異なる値や、置き換えられるリストなどの異なる値が衝突として扱われます。同じ値は衝突になりません。
+//
+// WithConflictPolicy sets how colliding values that cannot be merged are handled.
+// Values of different types and different values such as replaced lists are conflicts. Equal values are not.
+func WithConflictPolicy(policy ConflictPolicy) func(*mergeVariablesOptions) {
+	return func(opts *mergeVariablesOptions) {
+		opts.conflict = policy
+	}
+}
+
+// MergeConflictError は ConflictError のときに値が衝突したことを示すエラーです。
+// MergeConflictError reports colliding values under ConflictError.
+type MergeConflictError struct {
+	// Path は 衝突した値の位置です。 Path is where the values collided.
+	Path hcl.Traversal
+	// Existing は 先の値です。 Existing is the earlier value.
+	Existing cty.Value
+	// Incoming は 後の値です。 Incoming is the later value.
+	Incoming cty.Value
+}
+
+// Error は 衝突した位置と両方の値を返します。SensitiveMark が付与された値は伏せ字になります。
+// Error returns the path and both values. Values marked with SensitiveMark are redacted.
+func (err *MergeConflictError) Error() string {
+	return fmt.Sprintf("conflicting values at %s: %s (%s) and %s (%s)",
+		TraversalToString(err.Path),
+		dumpVisibleValue(err.Existing), err.Existing.Type().FriendlyName(),
+		dumpVisibleValue(err.Incoming), err.Incoming.Type().FriendlyName())
+}
+
+// MergeVariablesWithOptions は 複数の変数を順にマージします。
+// デフォルトでは MergeVariables と同じく、オブジェクトは再帰的にマージし、それ以外は後の値で置き換えます。
+//
+// MergeVariablesWithOptions merges multiple variables in order.
+// By default, like MergeVariables, objects are merged recursively and other values are replaced by later ones.
+//
+//	vars, err := hclutil.MergeVariablesWithOptions(
+//		[]map[string]cty.Value{base, env},
+//		hclutil.WithMapMerge(),
+//		hclutil.WithListMergeStrategy(hclutil.ListAppend),
+//		hclutil.WithConflictPolicy(hclutil.ConflictError),
+//	)
+func MergeVariablesWithOptions(vars []map[string]cty.Value, optFns ...func(*mergeVariablesOptions)) (map[string]cty.Value, error) {
+	opts := &mergeVariablesOptions{}
+	for _, optFn := range optFns {
+		optFn(opts)
+	}
 	var result map[string]cty.Value
 	for _, v := range vars {
-		result = mergeVariables(result, v)
+		if result == nil {
+			result = make(map[string]cty.Value, len(v))
+		}
+		for _, key := range sortedKeys(v) {
+			value := v[key]
+			existing, ok := result[key]
+			if !ok {
+				result[key] = value
+				continue
+			}
+			merged, err := opts.merge(hcl.Traversal{hcl.TraverseRoot{Name: key}}, existing, value)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = merged
+		}
 	}
-	return result
+	return result, nil
 }
 
-func mergeVariables(dst map[string]cty.Value, src map[string]cty.Value) map[string]cty.Value {
-	if dst == nil {
-		dst = make(map[string]cty.Value, len(src))
+func (opts *mergeVariablesOptions) merge(path hcl.Traversal, dst, src cty.Value) (cty.Value, error) {
+	if dst.IsKnown() && src.IsKnown() && !dst.IsNull() && !src.IsNull() {
+		dstVal, dstMarks := dst.Unmark()
+		srcVal, srcMarks := src.Unmark()
+		merged, ok, err := opts.mergeCollections(path, dstVal, srcVal)
+		if err != nil {
+			return cty.NilVal, err
+		}
+		if ok {
+			return merged.WithMarks(dstMarks, srcMarks), nil
+		}
 	}
-	for key, value := range src {
-		dstValue, ok := dst[key]
-		if !ok {
-			dst[key] = value
-			continue
+	if dst.RawEquals(src) {
+		return dst, nil
+	}
+	switch opts.conflict {
+	case ConflictFirstWins:
+		return dst, nil
+	case ConflictError:
+		return cty.NilVal, &MergeConflictError{
+			Path:     path,
+			Existing: dst,
+			Incoming: src,
 		}
-		if !dstValue.Type().IsObjectType() {
-			dst[key] = value
-			continue
+	default:
+		return src, nil
+	}
+}
+
+// mergeCollections は 設定に従ってマージできるコレクションをマージします。マージできない組み合わせの場合は false を返します。
+func (opts *mergeVariablesOptions) mergeCollections(path hcl.Traversal, dst, src cty.Value) (cty.Value, bool, error) {
+	dstTy, srcTy := dst.Type(), src.Type()
+	switch {
+	case dstTy.IsObjectType() && srcTy.IsObjectType():
+		elems, err := opts.mergeElements(path, dst.AsValueMap(), src.AsValueMap(), func(key string) hcl.Traverser {
+			return hcl.TraverseAttr{Name: key}
+		})
+		if err != nil {
+			return cty.NilVal, false, err
+		}
+		return cty.ObjectVal(elems), true, nil
+	case opts.mergeMaps && dstTy.IsMapType() && srcTy.IsMapType():
+		elems, err := opts.mergeElements(path, dst.AsValueMap(), src.AsValueMap(), func(key string) hcl.Traverser {
+			return hcl.TraverseIndex{Key: cty.StringVal(key)}
+		})
+		if err != nil {
+			return cty.NilVal, false, err
 		}
-		if !value.Type().IsObjectType() {
-			dst[key] = value
+		if len(elems) == 0 {
+			return dst, true, nil
+		}
+		if sameElementTypes(mapElements(elems)) {
+			return cty.MapVal(elems), true, nil
+		}
+		return cty.ObjectVal(elems), true, nil
+	case opts.lists == ListAppend && isListOrTuple(dstTy) && isListOrTuple(srcTy):
+		elems := append(dst.AsValueSlice(), src.AsValueSlice()...)
+		if len(elems) == 0 {
+			return dst, true, nil
+		}
+		if dstTy.IsListType() && srcTy.IsListType() && sameElementTypes(elems) {
+			return cty.ListVal(elems), true, nil
+		}
+		return cty.TupleVal(elems), true, nil
+	case opts.setUnion && dstTy.IsSetType() && srcTy.IsSetType() && dstTy.ElementType().Equals(srcTy.ElementType()):
+		elems := append(dst.AsValueSlice(), src.AsValueSlice()...)
+		if len(elems) == 0 {
+			return dst, true, nil
+		}
+		return cty.SetVal(elems), true, nil
+	}
+	return cty.NilVal, false, nil
+}
+
+func (opts *mergeVariablesOptions) mergeElements(path hcl.Traversal, dst, src map[string]cty.Value, step func(string) hcl.Traverser) (map[string]cty.Value, error) {
+	elems := make(map[string]cty.Value, len(dst)+len(src))
+	for key, value := range dst {
+		elems[key] = value
+	}
+	for _, key := range sortedKeys(src) {
+		value := src[key]
+		existing, ok := elems[key]
+		if !ok {
+			elems[key] = value
 			continue
 		}
-		dstValueMap := dstValue.AsValueMap()
-		srcValueMap := value.AsValueMap()
-		dst[key] = cty.ObjectVal(mergeVariables(dstValueMap, srcValueMap))
+		elemPath := make(hcl.Traversal, len(path), len(path)+1)
+		copy(elemPath, path)
+		merged, err := opts.merge(append(elemPath, step(key)), existing, value)
+		if err != nil {
+			return nil, err
+		}
+		elems[key] = merged
 	}
-	return dst
+	return elems, nil
+}
+
+func isListOrTuple(ty cty.Type) bool {
+	return ty.IsListType() || ty.IsTupleType()
 }
@@ -1,10 +1,12 @@
 package hclutil_test
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -46,3 +48,127 @@ func TestMergeVariables(t *testing.T) {
 		t.Errorf("got: %#v, want: %#v", actual, expectd)
 	}
 }
+
+func TestMergeVariablesWithOptions(t *testing.T) {
+	base := map[string]cty.Value{
+		"var": cty.ObjectVal(map[string]cty.Value{
+			"tags": cty.MapVal(map[string]cty.Value{
+				"team": cty.StringVal("infra"),
+				"env":  cty.StringVal("dev"),
+			}),
+			"subnets": cty.ListVal([]cty.Value{cty.StringVal("a")}),
+			"zones":   cty.SetVal([]cty.Value{cty.StringVal("1a"), cty.StringVal("1c")}),
+			"port":    cty.NumberIntVal(80),
+		}),
+	}
+	env := map[string]cty.Value{
+		"var": cty.ObjectVal(map[string]cty.Value{
+			"tags": cty.MapVal(map[string]cty.Value{
+				"env": cty.StringVal("prod"),
+			}),
+			"subnets": cty.ListVal([]cty.Value{cty.StringVal("b")}),
+			"zones":   cty.SetVal([]cty.Value{cty.StringVal("1d")}),
+			"port":    cty.NumberIntVal(8080),
+		}),
+	}
+
+	cases := []struct {
+		name    string
+		merge   func(vars []map[string]cty.Value) (map[string]cty.Value, error)
+		want    cty.Value
+		wantErr string
+	}{
+		{
+			name: "default",
+			merge: func(vars []map[string]cty.Value) (map[string]cty.Value, error) {
+				return hclutil.MergeVariablesWithOptions(vars)
+			},
+			want: cty.ObjectVal(map[string]cty.Value{
+				"tags":    cty.MapVal(map[string]cty.Value{"env": cty.StringVal("prod")}),
+				"subnets": cty.ListVal([]cty.Value{cty.StringVal("b")}),
+				"zones":   cty.SetVal([]cty.Value{cty.StringVal("1d")}),
+				"port":    cty.NumberIntVal(8080),
+			}),
+		},
+		{
+			name: "deep",
+			merge: func(vars []map[string]cty.Value) (map[string]cty.Value, error) {
+				return hclutil.MergeVariablesWithOptions(vars,
+					hclutil.WithMapMerge(),
+					hclutil.WithListMergeStrategy(hclutil.ListAppend),
+					hclutil.WithSetUnion(),
+				)
+			},
+			want: cty.ObjectVal(map[string]cty.Value{
+				"tags": cty.MapVal(map[string]cty.Value{
+					"team": cty.StringVal("infra"),
+					"env":  cty.StringVal("prod"),
+				}),
+				"subnets": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+				"zones":   cty.SetVal([]cty.Value{cty.StringVal("1a"), cty.StringVal("1c"), cty.StringVal("1d")}),
+				"port":    cty.NumberIntVal(8080),
+			}),
+		},
+		{
+			name: "first wins",
+			merge: func(vars []map[string]cty.Value) (map[string]cty.Value, error) {
+				return hclutil.MergeVariablesWithOptions(vars,
+					hclutil.WithMapMerge(),
+					hclutil.WithListMergeStrategy(hclutil.ListAppend),
+					hclutil.WithSetUnion(),
+					hclutil.WithConflictPolicy(hclutil.ConflictFirstWins),
+				)
+			},
+			want: cty.ObjectVal(map[string]cty.Value{
+				"tags": cty.MapVal(map[string]cty.Value{
+					"team": cty.StringVal("infra"),
+					"env":  cty.StringVal("dev"),
+				}),
+				"subnets": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+				"zones":   cty.SetVal([]cty.Value{cty.StringVal("1a"), cty.StringVal("1c"), cty.StringVal("1d")}),
+				"port":    cty.NumberIntVal(80),
+			}),
+		},
+		{
+			name: "error",
+			merge: func(vars []map[string]cty.Value) (map[string]cty.Value, error) {
+				return hclutil.MergeVariablesWithOptions(vars,
+					hclutil.WithMapMerge(),
+					hclutil.WithListMergeStrategy(hclutil.ListAppend),
+					hclutil.WithSetUnion(),
+					hclutil.WithConflictPolicy(hclutil.ConflictError),
+				)
+			},
+			wantErr: `conflicting values at var.port: 80 (number) and 8080 (number)`,
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.merge([]map[string]cty.Value{base, env})
+			if c.wantErr != "" {
+				require.EqualError(t, err, c.wantErr)
+				var conflictErr *hclutil.MergeConflictError
+				require.True(t, errors.As(err, &conflictErr))
+				require.Equal(t, cty.NumberIntVal(80), conflictErr.Existing)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, c.want.Equals(got["var"]).True(), "got: %s", hclutil.MustDumpCtyValue(got["var"]))
+		})
+	}
+}
+
+func TestMergeVariablesWithOptions__MapKeyConflict(t *testing.T) {
+	_, err := hclutil.MergeVariablesWithOptions([]map[string]cty.Value{
+		{"tags": cty.MapVal(map[string]cty.Value{"app.kubernetes.io/name": cty.StringVal("a")})},
+		{"tags": cty.MapVal(map[string]cty.Value{"app.kubernetes.io/name": cty.StringVal("b")})},
+	}, hclutil.WithMapMerge(), hclutil.WithConflictPolicy(hclutil.ConflictError))
+	require.EqualError(t, err, `conflicting values at tags["app.kubernetes.io/name"]: "a" (string) and "b" (string)`)
+
+	_, err = hclutil.MergeVariablesWithOptions([]map[string]cty.Value{
+		{"password": cty.StringVal("old").Mark(hclutil.SensitiveMark)},
+		{"password": cty.StringVal("new")},
+	}, hclutil.WithConflictPolicy(hclutil.ConflictError))
+	require.EqualError(t, err, `conflicting values at password: "(sensitive value)" (string) and "new" (string)`)
+}
@@ -0,0 +1,199 @@
+package hclutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+type watchOptions struct {
+	interval      time.Duration
+	debounce      time.Duration
+	parseOptFns   []func(*parseOptions)
+	funcOptFns    []func(*utilFunctionOptions)
+	onDiagnostics func(hcl.Diagnostics, *DiagnosticsWriter)
+}
+
+// WithWatchInterval は ファイルの変更を確認する間隔を指定します。デフォルトは1秒です。
+// WithWatchInterval sets the interval for checking file changes. The default is 1 second.
+func WithWatchInterval(d time.Duration) func(*watchOptions) {
+	return func(opts *watchOptions) {
+		opts.interval = d
+	}
+}
+
+// WithWatchDebounce は 最後の変更からこの時間が経つまで再読み込みを待ちます。連続した書き込みをまとめるために使います。デフォルトは200ミリ秒です。
+// WithWatchDebounce waits until this duration has passed since the last change before reloading, to coalesce bursts of writes.
+// The default is 200 milliseconds.
+func WithWatchDebounce(d time.Duration) func(*watchOptions) {
+	return func(opts *watchOptions) {
+		opts.debounce = d
+	}
+}
+
+// WithWatchParseOptions は 解析に使うオプション(WithFileExtension など)を指定します。
+// WithWatchParseOptions sets the options used for parsing, such as WithFileExtension.
+func WithWatchParseOptions(optFns ...func(*parseOptions)) func(*watchOptions) {
+	return func(opts *watchOptions) {
+		opts.parseOptFns = append(opts.parseOptFns, optFns...)
+	}
+}
+
+// WithWatchFunctionOptions は NewEvalContext に渡すオプションを指定します。
+// WithWatchFunctionOptions sets the options passed to NewEvalContext.
+func WithWatchFunctionOptions(optFns ...func(*utilFunctionOptions)) func(*watchOptions) {
+	return func(opts *watchOptions) {
+		opts.funcOptFns = append(opts.funcOptFns, optFns...)
+	}
+}
+
+// WithWatchDiagnostics は 再読み込みで診断情報が出たときに呼ばれる関数を指定します。
+// エラーがある場合、設定は onChange に渡されず、この関数にだけ診断情報が渡されます。
+//
+// WithWatchDiagnostics sets a function called when reloading produced diagnostics.
+// When there are errors, the configuration is not passed to onChange and only this function receives the diagnostics.
+func WithWatchDiagnostics(fn func(hcl.Diagnostics, *DiagnosticsWriter)) func(*watchOptions) {
+	return func(opts *watchOptions) {
+		opts.onDiagnostics = fn
+	}
+}
+
+// Watch は path を監視し、ファイルが変更されるたびに Parse, NewEvalContext, DecodeLocals, decodeFn を実行し直します。
+// 最初に一度読み込み、その後はポーリングで変更を検出します。連続した書き込みは WithWatchDebounce の時間だけまとめられます。
+// エラーの診断情報がない場合だけ、新しい設定が onChange に渡されます。
+// 内容が変わっていないファイルは ParseCache により再解析されません。
+// ctx がキャンセルされるまで戻らず、ctx.Err() を返します。
+//
+// Watch watches path and re-runs Parse, NewEvalContext, DecodeLocals and decodeFn every time files change.
+// It loads once at the start and then detects changes by polling. Bursts of writes are coalesced for the WithWatchDebounce duration.
+// A new configuration is passed to onChange only when there are no error diagnostics.
+// Files whose content has not changed are not re-parsed thanks to ParseCache.
+// It does not return until ctx is canceled, and returns ctx.Err().
+func Watch[T any](
+	ctx context.Context,
+	path string,
+	decodeFn func(body hcl.Body, evalCtx *hcl.EvalContext) (T, hcl.Diagnostics),
+	onChange func(T),
+	optFns ...func(*watchOptions),
+) error {
+	opts := &watchOptions{
+		interval: time.Second,
+		debounce: 200 * time.Millisecond,
+	}
+	for _, optFn := range optFns {
+		optFn(opts)
+	}
+	cache := NewParseCache(opts.parseOptFns...)
+	reload := func() {
+		cfg, writer, diags := decodeWatchedConfig(cache, path, decodeFn, opts)
+		if len(diags) > 0 && opts.onDiagnostics != nil {
+			opts.onDiagnostics(diags, writer)
+		}
+		if !diags.HasErrors() {
+			onChange(cfg)
+		}
+	}
+
+	prev := watchSnapshot(path)
+	reload()
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	var pending bool
+	var changedAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			cur := watchSnapshot(path)
+			if !cur.equal(prev) {
+				prev = cur
+				pending = true
+				changedAt = now
+				continue
+			}
+			if pending && now.Sub(changedAt) >= opts.debounce {
+				pending = false
+				reload()
+			}
+		}
+	}
+}
+
+func decodeWatchedConfig[T any](
+	cache *ParseCache,
+	path string,
+	decodeFn func(body hcl.Body, evalCtx *hcl.EvalContext) (T, hcl.Diagnostics),
+	opts *watchOptions,
+) (T, *DiagnosticsWriter, hcl.Diagnostics) {
+	var cfg T
+	body, writer, diags := cache.Parse(path)
+	if diags.HasErrors() {
+		return cfg, writer, diags
+	}
+	evalCtx := NewEvalContext(opts.funcOptFns...)
+	body, evalCtx, d := DecodeLocals(body, evalCtx)
+	diags = append(diags, d...)
+	if diags.HasErrors() {
+		return cfg, writer, diags
+	}
+	cfg, d = decodeFn(body, evalCtx)
+	diags = append(diags, d...)
+	return cfg, writer, diags
+}
+
+type watchStamp struct {
+	modTime time.Time
+	size    int64
+	err     string
+}
+
+// watchSnapshotMap は 監視対象のファイルごとの更新時刻と大きさです。
+type watchSnapshotMap map[string]watchStamp
+
+func watchSnapshot(path string) watchSnapshotMap {
+	snapshot := make(watchSnapshotMap)
+	stat, err := os.Stat(path)
+	if err != nil {
+		snapshot[path] = watchStamp{err: err.Error()}
+		return snapshot
+	}
+	if !stat.IsDir() {
+		snapshot[path] = watchStamp{modTime: stat.ModTime(), size: stat.Size()}
+		return snapshot
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		snapshot[path] = watchStamp{err: err.Error()}
+		return snapshot
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := filepath.Join(path, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			snapshot[name] = watchStamp{err: err.Error()}
+			continue
+		}
+		snapshot[name] = watchStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return snapshot
+}
+
+func (s watchSnapshotMap) equal(other watchSnapshotMap) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for name, stamp := range s {
+		o, ok := other[name]
+		if !ok || !stamp.modTime.Equal(o.modTime) || stamp.size != o.size || stamp.err != o.err {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package hclutil_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.hcl")
+	require.NoError(t, os.WriteFile(configPath, []byte("locals {\n  name = \"v1\"\n}\nname = local.name\n"), 0o644))
+
+	type config struct {
+		Name string `hcl:"name"`
+	}
+	decodeFn := func(body hcl.Body, evalCtx *hcl.EvalContext) (config, hcl.Diagnostics) {
+		var cfg config
+		diags := gohcl.DecodeBody(body, evalCtx, &cfg)
+		return cfg, diags
+	}
+	configs := make(chan config, 10)
+	diagsCh := make(chan hcl.Diagnostics, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- hclutil.Watch(ctx, dir, decodeFn, func(cfg config) {
+			configs <- cfg
+		},
+			hclutil.WithWatchInterval(10*time.Millisecond),
+			hclutil.WithWatchDebounce(30*time.Millisecond),
+			hclutil.WithWatchDiagnostics(func(diags hcl.Diagnostics, _ *hclutil.DiagnosticsWriter) {
+				diagsCh <- diags
+			}),
+		)
+	}()
+
+	receive := func() config {
+		t.Helper()
+		select {
+		case cfg := <-configs:
+			return cfg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for config")
+		}
+		return config{}
+	}
+	require.Equal(t, config{Name: "v1"}, receive())
+
+	require.NoError(t, os.WriteFile(configPath, []byte("locals {\n  name = \"v2-updated\"\n}\nname = local.name\n"), 0o644))
+	require.Equal(t, config{Name: "v2-updated"}, receive())
+
+	require.NoError(t, os.WriteFile(configPath, []byte("name = local.undefined_value\n"), 0o644))
+	select {
+	case diags := <-diagsCh:
+		require.True(t, diags.HasErrors())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for diagnostics")
+	}
+	select {
+	case cfg := <-configs:
+		t.Fatalf("unexpected config: %v", cfg)
+	default:
+	}
+
+	cancel()
+	require.True(t, errors.Is(<-errCh, context.Canceled))
+}
@@ -0,0 +1,646 @@
+package hclutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl/v2"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML は YAMLをHCLのJSON構文と同じ意味を持つ hcl.File に変換します。
+// YAMLを一度JSONに変換してから hcljson で解析し、Body や式が返す Range を元のYAMLの位置に写し戻します。
+//
+// parseYAML converts YAML into an hcl.File with the same semantics as the HCL JSON syntax.
+// The YAML is converted to JSON and parsed with hcljson, and the ranges returned by
+// the body and its expressions are mapped back to the positions in the YAML source.
+func parseYAML(src []byte, filename string) (*hcl.File, hcl.Diagnostics) {
+	m := newYAMLPosMap(filename, src)
+	dec := yaml.NewDecoder(bytes.NewReader(src))
+	var root yaml.Node
+	if err := dec.Decode(&root); err != nil && err != io.EOF {
+		return nil, hcl.Diagnostics{m.syntaxError(err)}
+	}
+	var extra yaml.Node
+	if err := dec.Decode(&extra); err != io.EOF {
+		if err != nil {
+			return nil, hcl.Diagnostics{m.syntaxError(err)}
+		}
+		c := &yamlConverter{m: m}
+		c.errorf(&extra, "Invalid YAML configuration", "A YAML configuration file must contain a single document, but another document was found.")
+		return nil, c.diags
+	}
+	c := &yamlConverter{m: m}
+	c.document(&root)
+	if c.diags.HasErrors() {
+		return nil, c.diags
+	}
+	file, diags := hcljson.Parse(c.buf.Bytes(), filename)
+	diags = m.diagnostics(diags)
+	if file == nil {
+		return nil, diags
+	}
+	return &hcl.File{
+		Body:  &yamlBody{body: file.Body, m: m},
+		Bytes: src,
+	}, diags
+}
+
+var yamlErrorLinePattern = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func (m *yamlPosMap) syntaxError(err error) *hcl.Diagnostic {
+	diag := &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid YAML syntax",
+		Detail:   err.Error(),
+	}
+	matches := yamlErrorLinePattern.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return diag
+	}
+	line, convErr := strconv.Atoi(matches[1])
+	if convErr != nil || line < 1 || line > len(m.lineStarts) {
+		return diag
+	}
+	diag.Detail = matches[2]
+	start := m.lineStarts[line-1]
+	end := m.lineEnd(start)
+	diag.Subject = &hcl.Range{
+		Filename: m.filename,
+		Start:    hcl.Pos{Line: line, Column: 1, Byte: start},
+		End:      hcl.Pos{Line: line, Column: 1 + utf8.RuneCount(m.src[start:end]), Byte: end},
+	}
+	return diag
+}
+
+// yamlPosMap は 生成したJSONのバイトオフセットとYAMLの位置の対応を保持します。
+type yamlPosMap struct {
+	filename   string
+	src        []byte
+	lineStarts []int
+	marks      []yamlPosMark
+}
+
+// yamlPosMark は 生成したJSONの1つのトークンとYAMLのトークンの対応です。
+// skip はYAML側に対応するものがないJSONのバイト数(文字列の開きの引用符など)、
+// length はYAML側のトークンのバイト数です。
+type yamlPosMark struct {
+	json   int
+	yaml   hcl.Pos
+	skip   int
+	length int
+}
+
+func newYAMLPosMap(filename string, src []byte) *yamlPosMap {
+	lineStarts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &yamlPosMap{
+		filename:   filename,
+		src:        src,
+		lineStarts: lineStarts,
+	}
+}
+
+func (m *yamlPosMap) lineEnd(start int) int {
+	end := bytes.IndexByte(m.src[start:], '\n')
+	if end < 0 {
+		return len(m.src)
+	}
+	if end > 0 && m.src[start+end-1] == '\r' {
+		end--
+	}
+	return start + end
+}
+
+// nodePos は yaml.Node の行と列(文字単位)をバイトオフセットを含む hcl.Pos に変換します。
+func (m *yamlPosMap) nodePos(node *yaml.Node) hcl.Pos {
+	if node.Line < 1 || node.Line > len(m.lineStarts) {
+		return hcl.Pos{Line: 1, Column: 1, Byte: 0}
+	}
+	offset := m.lineStarts[node.Line-1]
+	end := m.lineEnd(offset)
+	for col := 1; col < node.Column && offset < end; col++ {
+		_, size := utf8.DecodeRune(m.src[offset:end])
+		offset += size
+	}
+	return hcl.Pos{Line: node.Line, Column: node.Column, Byte: offset}
+}
+
+// tokenLength は node のYAMLソース上のトークンのバイト数を、同じ行の範囲で返します。
+func (m *yamlPosMap) tokenLength(node *yaml.Node, pos hcl.Pos) int {
+	end := m.lineEnd(pos.Byte)
+	rest := m.src[pos.Byte:end]
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+				continue
+			}
+			if rest[i] == '"' {
+				return i + 1
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := 1; i < len(rest); i++ {
+			if rest[i] != '\'' {
+				continue
+			}
+			if i+1 < len(rest) && rest[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	case yaml.LiteralStyle, yaml.FoldedStyle:
+		return 1
+	default:
+		if bytes.HasPrefix(rest, []byte(node.Value)) {
+			return len(node.Value)
+		}
+	}
+	return len(rest)
+}
+
+func (m *yamlPosMap) mark(jsonOffset int, node *yaml.Node, quoted bool) {
+	pos := m.nodePos(node)
+	mark := yamlPosMark{json: jsonOffset, yaml: pos}
+	if node.Kind == yaml.ScalarNode {
+		mark.length = m.tokenLength(node, pos)
+		if quoted && (node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle)) == 0 {
+			mark.skip = 1
+		}
+	}
+	m.marks = append(m.marks, mark)
+}
+
+func (m *yamlPosMap) pos(jsonPos hcl.Pos) hcl.Pos {
+	i := sort.Search(len(m.marks), func(i int) bool {
+		return m.marks[i].json > jsonPos.Byte
+	}) - 1
+	if i < 0 {
+		return hcl.Pos{Line: 1, Column: 1, Byte: 0}
+	}
+	mark := m.marks[i]
+	delta := jsonPos.Byte - mark.json - mark.skip
+	if delta < 0 {
+		delta = 0
+	}
+	if delta > mark.length {
+		delta = mark.length
+	}
+	offset := mark.yaml.Byte + delta
+	return hcl.Pos{
+		Line:   mark.yaml.Line,
+		Column: mark.yaml.Column + utf8.RuneCount(m.src[mark.yaml.Byte:offset]),
+		Byte:   offset,
+	}
+}
+
+func (m *yamlPosMap) rng(r hcl.Range) hcl.Range {
+	if r.Filename != m.filename {
+		return r
+	}
+	return hcl.Range{
+		Filename: r.Filename,
+		Start:    m.pos(r.Start),
+		End:      m.pos(r.End),
+	}
+}
+
+func (m *yamlPosMap) rangePtr(r *hcl.Range) *hcl.Range {
+	if r == nil {
+		return nil
+	}
+	return m.rng(*r).Ptr()
+}
+
+func (m *yamlPosMap) diagnostics(diags hcl.Diagnostics) hcl.Diagnostics {
+	if len(diags) == 0 {
+		return diags
+	}
+	ret := make(hcl.Diagnostics, len(diags))
+	for i, diag := range diags {
+		d := *diag
+		d.Subject = m.rangePtr(d.Subject)
+		d.Context = m.rangePtr(d.Context)
+		ret[i] = &d
+	}
+	return ret
+}
+
+func (m *yamlPosMap) traversal(t hcl.Traversal) hcl.Traversal {
+	if t == nil {
+		return nil
+	}
+	ret := make(hcl.Traversal, len(t))
+	for i, tr := range t {
+		switch tr := tr.(type) {
+		case hcl.TraverseRoot:
+			tr.SrcRange = m.rng(tr.SrcRange)
+			ret[i] = tr
+		case hcl.TraverseAttr:
+			tr.SrcRange = m.rng(tr.SrcRange)
+			ret[i] = tr
+		case hcl.TraverseIndex:
+			tr.SrcRange = m.rng(tr.SrcRange)
+			ret[i] = tr
+		case hcl.TraverseSplat:
+			tr.SrcRange = m.rng(tr.SrcRange)
+			tr.Each = m.traversal(tr.Each)
+			ret[i] = tr
+		default:
+			ret[i] = tr
+		}
+	}
+	return ret
+}
+
+// maxYAMLAliasNodes は エイリアスの展開で生成できるノードの数の上限です。
+// エイリアスを入れ子にして指数的に大きくなる文書(billion laughs)を防ぎます。
+const maxYAMLAliasNodes = 100000
+
+// yamlConverter は yaml.Node をJSONに変換しながら、位置の対応を記録します。
+type yamlConverter struct {
+	buf   bytes.Buffer
+	m     *yamlPosMap
+	diags hcl.Diagnostics
+
+	aliasDepth int
+	aliasNodes int
+	aborted    bool
+}
+
+func (c *yamlConverter) errorf(node *yaml.Node, summary string, format string, args ...interface{}) {
+	pos := c.m.nodePos(node)
+	// スカラー以外はトークンの先頭の1文字(インジケータ)を指す
+	length := c.m.lineEnd(pos.Byte) - pos.Byte
+	if node.Kind == yaml.ScalarNode {
+		length = c.m.tokenLength(node, pos)
+	} else if length > 1 {
+		length = 1
+	}
+	end := hcl.Pos{
+		Line:   pos.Line,
+		Column: pos.Column + utf8.RuneCount(c.m.src[pos.Byte:pos.Byte+length]),
+		Byte:   pos.Byte + length,
+	}
+	c.diags = append(c.diags, &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  summary,
+		Detail:   fmt.Sprintf(format, args...),
+		Subject:  &hcl.Range{Filename: c.m.filename, Start: pos, End: end},
+	})
+}
+
+// expand は エイリアスやマージキーで展開したノードを数え、上限を超えた場合はエラーを報告して false を返します。
+func (c *yamlConverter) expand(node *yaml.Node) bool {
+	c.aliasNodes++
+	if c.aliasNodes > maxYAMLAliasNodes {
+		c.errorf(node, "Invalid YAML configuration", "Expanding aliases produces more than %d values.", maxYAMLAliasNodes)
+		c.aborted = true
+		return false
+	}
+	return true
+}
+
+func (c *yamlConverter) document(node *yaml.Node) {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			c.buf.WriteString("{}")
+			return
+		}
+		node = node.Content[0]
+	}
+	if node.Kind == 0 {
+		c.buf.WriteString("{}")
+		return
+	}
+	if resolveYAMLAlias(node).Kind != yaml.MappingNode {
+		c.errorf(node, "Invalid YAML configuration", "The root of a YAML configuration file must be a mapping.")
+		return
+	}
+	c.value(node)
+}
+
+func (c *yamlConverter) value(node *yaml.Node) {
+	if c.aborted {
+		return
+	}
+	if c.aliasDepth > 0 && !c.expand(node) {
+		return
+	}
+	switch node.Kind {
+	case yaml.AliasNode:
+		c.aliasDepth++
+		c.value(node.Alias)
+		c.aliasDepth--
+	case yaml.MappingNode:
+		c.m.mark(c.buf.Len(), node, false)
+		c.buf.WriteByte('{')
+		entries := c.mappingEntries(node, make(map[*yaml.Node]bool))
+		if c.aborted {
+			return
+		}
+		for i, entry := range entries {
+			if i > 0 {
+				c.buf.WriteByte(',')
+			}
+			key := resolveYAMLAlias(entry[0])
+			if key.Kind != yaml.ScalarNode {
+				c.errorf(entry[0], "Invalid YAML key", "Mapping keys must be strings.")
+				return
+			}
+			c.m.mark(c.buf.Len(), key, true)
+			c.string(key.Value)
+			c.buf.WriteByte(':')
+			c.value(entry[1])
+		}
+		c.buf.WriteByte('}')
+	case yaml.SequenceNode:
+		c.m.mark(c.buf.Len(), node, false)
+		c.buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				c.buf.WriteByte(',')
+			}
+			c.value(item)
+		}
+		c.buf.WriteByte(']')
+	case yaml.ScalarNode:
+		c.scalar(node)
+	default:
+		c.errorf(node, "Invalid YAML value", "Unsupported YAML node.")
+	}
+}
+
+func (c *yamlConverter) scalar(node *yaml.Node) {
+	switch node.ShortTag() {
+	case "!!null":
+		c.m.mark(c.buf.Len(), node, false)
+		c.buf.WriteString("null")
+	case "!!bool", "!!int", "!!float":
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			c.errorf(node, "Invalid YAML value", "%s", err.Error())
+			return
+		}
+		bs, err := json.Marshal(v)
+		if err != nil {
+			c.errorf(node, "Invalid YAML value", "The value %q cannot be represented in the configuration.", node.Value)
+			return
+		}
+		c.m.mark(c.buf.Len(), node, false)
+		c.buf.Write(bs)
+	default:
+		c.m.mark(c.buf.Len(), node, true)
+		c.string(node.Value)
+	}
+}
+
+func (c *yamlConverter) string(s string) {
+	// json.Marshal は '<' などをエスケープしてオフセットがずれるため、HTMLエスケープを無効にする
+	enc := json.NewEncoder(&c.buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return
+	}
+	// Encode が末尾に付ける改行を取り除く
+	c.buf.Truncate(c.buf.Len() - 1)
+}
+
+func resolveYAMLAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// mappingEntries は マッピングのキーと値の組を返します。
+// マージキー(<<)で取り込まれた組は先に並び、明示的に書かれたキーが優先されます。
+// path は 展開中のマージの経路にあるマッピングで、自身を取り込むマージキーはエラーになります。
+// 取り込んだマッピングと組はエイリアスと同じ上限で数えられ、同じマッピングは1度だけ展開されます。
+func (c *yamlConverter) mappingEntries(node *yaml.Node, path map[*yaml.Node]bool) [][2]*yaml.Node {
+	node = resolveYAMLAlias(node)
+	path[node] = true
+	defer delete(path, node)
+	var merged []*yaml.Node
+	expanded := make(map[*yaml.Node]bool)
+	explicit := make([][2]*yaml.Node, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge" {
+			for _, source := range yamlMergeSources(value) {
+				if path[source] {
+					c.errorf(value, "Invalid YAML configuration", "The merge key refers to a mapping that contains it.")
+					c.aborted = true
+					return nil
+				}
+				if !expanded[source] {
+					expanded[source] = true
+					merged = append(merged, source)
+				}
+			}
+			continue
+		}
+		explicit = append(explicit, [2]*yaml.Node{key, value})
+	}
+	if len(merged) == 0 {
+		return explicit
+	}
+	seen := make(map[string]bool, len(explicit))
+	for _, entry := range explicit {
+		seen[resolveYAMLAlias(entry[0]).Value] = true
+	}
+	entries := make([][2]*yaml.Node, 0, len(explicit))
+	for _, source := range merged {
+		if !c.expand(source) {
+			return nil
+		}
+		sourceEntries := c.mappingEntries(source, path)
+		if c.aborted {
+			return nil
+		}
+		for _, entry := range sourceEntries {
+			if !c.expand(entry[0]) {
+				return nil
+			}
+			key := resolveYAMLAlias(entry[0]).Value
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			entries = append(entries, entry)
+		}
+	}
+	return append(entries, explicit...)
+}
+
+func yamlMergeSources(node *yaml.Node) []*yaml.Node {
+	node = resolveYAMLAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		return []*yaml.Node{node}
+	case yaml.SequenceNode:
+		sources := make([]*yaml.Node, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item = resolveYAMLAlias(item); item.Kind == yaml.MappingNode {
+				sources = append(sources, item)
+			}
+		}
+		return sources
+	}
+	return nil
+}
+
+// yamlBody は hcljson の Body をラップし、Range をYAMLの位置に写し戻します。
+type yamlBody struct {
+	body hcl.Body
+	m    *yamlPosMap
+}
+
+func (b *yamlBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, diags := b.body.Content(schema)
+	return b.content(content), b.m.diagnostics(diags)
+}
+
+func (b *yamlBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	content, remain, diags := b.body.PartialContent(schema)
+	if remain != nil {
+		remain = &yamlBody{body: remain, m: b.m}
+	}
+	return b.content(content), remain, b.m.diagnostics(diags)
+}
+
+func (b *yamlBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	attrs, diags := b.body.JustAttributes()
+	return b.attributes(attrs), b.m.diagnostics(diags)
+}
+
+func (b *yamlBody) MissingItemRange() hcl.Range {
+	return b.m.rng(b.body.MissingItemRange())
+}
+
+func (b *yamlBody) content(content *hcl.BodyContent) *hcl.BodyContent {
+	if content == nil {
+		return nil
+	}
+	blocks := make(hcl.Blocks, len(content.Blocks))
+	for i, block := range content.Blocks {
+		labelRanges := make([]hcl.Range, len(block.LabelRanges))
+		for j, r := range block.LabelRanges {
+			labelRanges[j] = b.m.rng(r)
+		}
+		blocks[i] = &hcl.Block{
+			Type:        block.Type,
+			Labels:      block.Labels,
+			Body:        &yamlBody{body: block.Body, m: b.m},
+			DefRange:    b.m.rng(block.DefRange),
+			TypeRange:   b.m.rng(block.TypeRange),
+			LabelRanges: labelRanges,
+		}
+	}
+	return &hcl.BodyContent{
+		Attributes:       b.attributes(content.Attributes),
+		Blocks:           blocks,
+		MissingItemRange: b.m.rng(content.MissingItemRange),
+	}
+}
+
+func (b *yamlBody) attributes(attrs hcl.Attributes) hcl.Attributes {
+	if attrs == nil {
+		return nil
+	}
+	ret := make(hcl.Attributes, len(attrs))
+	for name, attr := range attrs {
+		ret[name] = &hcl.Attribute{
+			Name:      attr.Name,
+			Expr:      &yamlExpression{expr: attr.Expr, m: b.m},
+			Range:     b.m.rng(attr.Range),
+			NameRange: b.m.rng(attr.NameRange),
+		}
+	}
+	return ret
+}
+
+// yamlExpression は hcljson の式をラップし、Range や診断情報をYAMLの位置に写し戻します。
+type yamlExpression struct {
+	expr hcl.Expression
+	m    *yamlPosMap
+}
+
+func (e *yamlExpression) Value(ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	v, diags := e.expr.Value(ctx)
+	return v, e.m.diagnostics(diags)
+}
+
+func (e *yamlExpression) Variables() []hcl.Traversal {
+	vars := e.expr.Variables()
+	ret := make([]hcl.Traversal, len(vars))
+	for i, t := range vars {
+		ret[i] = e.m.traversal(t)
+	}
+	return ret
+}
+
+func (e *yamlExpression) Range() hcl.Range {
+	return e.m.rng(e.expr.Range())
+}
+
+func (e *yamlExpression) StartRange() hcl.Range {
+	return e.m.rng(e.expr.StartRange())
+}
+
+func (e *yamlExpression) UnwrapExpression() hcl.Expression {
+	return e.expr
+}
+
+// AsTraversal は hcl.AbsTraversalForExpr などの静的解析で使われます。
+func (e *yamlExpression) AsTraversal() hcl.Traversal {
+	t, diags := hcl.AbsTraversalForExpr(e.expr)
+	if diags.HasErrors() {
+		return nil
+	}
+	return e.m.traversal(t)
+}
+
+// ExprList は hcl.ExprList での静的解析で使われます。
+func (e *yamlExpression) ExprList() []hcl.Expression {
+	exprs, diags := hcl.ExprList(e.expr)
+	if diags.HasErrors() {
+		return nil
+	}
+	ret := make([]hcl.Expression, len(exprs))
+	for i, expr := range exprs {
+		ret[i] = &yamlExpression{expr: expr, m: e.m}
+	}
+	return ret
+}
+
+// ExprMap は hcl.ExprMap での静的解析で使われます。
+func (e *yamlExpression) ExprMap() []hcl.KeyValuePair {
+	pairs, diags := hcl.ExprMap(e.expr)
+	if diags.HasErrors() {
+		return nil
+	}
+	ret := make([]hcl.KeyValuePair, len(pairs))
+	for i, pair := range pairs {
+		ret[i] = hcl.KeyValuePair{
+			Key:   &yamlExpression{expr: pair.Key, m: e.m},
+			Value: &yamlExpression{expr: pair.Value, m: e.m},
+		}
+	}
+	return ret
+}
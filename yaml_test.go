@@ -0,0 +1,194 @@
+package hclutil_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/mashiike/hclutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParse__YAMLFile(t *testing.T) {
+	t.Parallel()
+	body, writer, diags := hclutil.Parse("testdata/yaml_file.hcl.yaml")
+	diagsReport(t, diags)
+	require.NotNil(t, body)
+	require.ElementsMatch(t, []string{"testdata/yaml_file.hcl.yaml"}, writer.Files())
+	attrs, diags := body.JustAttributes()
+	diagsReport(t, diags)
+	attrKeys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		attrKeys = append(attrKeys, k)
+	}
+	require.ElementsMatch(t, []string{"boolean", "text", "number"}, attrKeys)
+	require.Equal(t, 2, attrs["number"].Expr.Range().Start.Line)
+	require.Equal(t, 9, attrs["number"].Expr.Range().Start.Column)
+
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	err := writer.WriteDiagnostics(hcl.Diagnostics{
+		{
+			Severity: hcl.DiagError,
+			Summary:  "test error",
+			Detail:   "test error detail",
+			Subject:  attrs["boolean"].Expr.Range().Ptr(),
+		},
+	})
+	require.EqualError(t, err, "diagnostics had errors, see above for details")
+	require.Equal(t, "Error: test error\n\n  on testdata/yaml_file.hcl.yaml line 3:\n   3: boolean: true\n\ntest error detail\n\n", buf.String())
+}
+
+func TestParseBytes__YAML(t *testing.T) {
+	t.Parallel()
+	src := []byte(`
+defaults: &defaults
+  port: 8080
+  tags: ["a", "b"]
+service:
+  api:
+    <<: *defaults
+    host: "${var.host}"
+    port: 9090
+  worker:
+    <<: *defaults
+    host: worker.local
+`)
+	body, _, diags := hclutil.ParseBytes("config.hcl.yaml", src)
+	diagsReport(t, diags)
+
+	type service struct {
+		Name string   `hcl:"name,label"`
+		Host string   `hcl:"host"`
+		Port int      `hcl:"port"`
+		Tags []string `hcl:"tags"`
+	}
+	type config struct {
+		Services []service `hcl:"service,block"`
+		Remain   hcl.Body  `hcl:",remain"`
+	}
+	ctx := hclutil.NewEvalContext()
+	ctx = hclutil.WithValue(ctx, "var.host", cty.StringVal("api.local"))
+	var cfg config
+	diags = gohcl.DecodeBody(body, ctx, &cfg)
+	diagsReport(t, diags)
+	require.ElementsMatch(t, []service{
+		{Name: "api", Host: "api.local", Port: 9090, Tags: []string{"a", "b"}},
+		{Name: "worker", Host: "worker.local", Port: 8080, Tags: []string{"a", "b"}},
+	}, cfg.Services)
+}
+
+func TestParseBytes__YAMLDiagnostics(t *testing.T) {
+	t.Parallel()
+	src := []byte("name: app\nurl: \"https://${var.host}/\"\n")
+	body, writer, diags := hclutil.ParseBytes("config.hcl.yml", src)
+	diagsReport(t, diags)
+	attrs, diags := body.JustAttributes()
+	diagsReport(t, diags)
+
+	vars := attrs["url"].Expr.Variables()
+	require.Len(t, vars, 1)
+	require.Equal(t, "var.host", hclutil.TraversalToString(vars[0]))
+	require.Equal(t, hcl.Pos{Line: 2, Column: 17, Byte: 26}, vars[0].SourceRange().Start)
+
+	_, diags = attrs["url"].Expr.Value(hclutil.NewEvalContext())
+	require.True(t, diags.HasErrors())
+	var buf bytes.Buffer
+	writer.SetOutput(&buf)
+	writer.SetColor(false)
+	writer.SetWidth(0)
+	require.Error(t, writer.WriteDiagnostics(diags))
+	require.Contains(t, buf.String(), "on config.hcl.yml line 2:")
+	require.Contains(t, buf.String(), `2: url: "https://${var.host}/"`)
+}
+
+func TestParseBytes__YAMLSyntaxError(t *testing.T) {
+	t.Parallel()
+	_, _, diags := hclutil.ParseBytes("config.hcl.yaml", []byte("name: app\n  port: 80\n"))
+	require.True(t, diags.HasErrors())
+	require.Equal(t, "Invalid YAML syntax", diags[0].Summary)
+	require.NotNil(t, diags[0].Subject)
+	require.Equal(t, 2, diags[0].Subject.Start.Line)
+
+	_, _, diags = hclutil.ParseBytes("config.hcl.yaml", []byte("- a\n- b\n"))
+	require.EqualError(t, diags, "config.hcl.yaml:1,1-2: Invalid YAML configuration; The root of a YAML configuration file must be a mapping.")
+}
+
+func TestParseBytes__YAMLMultipleDocuments(t *testing.T) {
+	t.Parallel()
+	_, _, diags := hclutil.ParseBytes("config.hcl.yaml", []byte("name: app\n---\nname: other\n"))
+	require.True(t, diags.HasErrors())
+	require.Equal(t, "Invalid YAML configuration", diags[0].Summary)
+	require.Equal(t, 2, diags[0].Subject.Start.Line)
+
+	body, _, diags := hclutil.ParseBytes("config.hcl.yaml", []byte("---\nname: app\n"))
+	diagsReport(t, diags)
+	attrs, diags := body.JustAttributes()
+	diagsReport(t, diags)
+	require.Contains(t, attrs, "name")
+}
+
+func TestParseBytes__YAMLAliasExpansionLimit(t *testing.T) {
+	t.Parallel()
+	t.Run("aliases", func(t *testing.T) {
+		t.Parallel()
+		var src bytes.Buffer
+		src.WriteString("a0: &a0 [x, x, x, x, x, x, x, x, x, x]\n")
+		for i := 1; i < 10; i++ {
+			fmt.Fprintf(&src, "a%d: &a%d [*a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d]\n", i, i, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1)
+		}
+		_, _, diags := hclutil.ParseBytes("config.hcl.yaml", src.Bytes())
+		require.True(t, diags.HasErrors())
+		require.Equal(t, "Invalid YAML configuration", diags[0].Summary)
+		require.Contains(t, diags[0].Detail, "Expanding aliases produces more than")
+	})
+	t.Run("repeated merge sources", func(t *testing.T) {
+		t.Parallel()
+		var src bytes.Buffer
+		src.WriteString("m0: &m0 {k: v}\n")
+		for i := 1; i <= 8; i++ {
+			fmt.Fprintf(&src, "m%d: &m%d {<<: [*m%d, *m%d, *m%d, *m%d, *m%d, *m%d, *m%d, *m%d, *m%d, *m%d]}\n", i, i, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1)
+		}
+		body, _, diags := hclutil.ParseBytes("config.hcl.yaml", src.Bytes())
+		diagsReport(t, diags)
+		attrs, diags := body.JustAttributes()
+		diagsReport(t, diags)
+		v, diags := attrs["m8"].Expr.Value(nil)
+		diagsReport(t, diags)
+		require.Equal(t, "v", v.GetAttr("k").AsString())
+	})
+	t.Run("distinct merge sources", func(t *testing.T) {
+		t.Parallel()
+		var src bytes.Buffer
+		src.WriteString("a0: &a0 {k: v}\nb0: &b0 {l: w}\n")
+		for i := 1; i < 40; i++ {
+			fmt.Fprintf(&src, "a%d: &a%d {<<: [*a%d, *b%d]}\nb%d: &b%d {<<: [*a%d, *b%d]}\n", i, i, i-1, i-1, i, i, i-1, i-1)
+		}
+		_, _, diags := hclutil.ParseBytes("config.hcl.yaml", src.Bytes())
+		require.True(t, diags.HasErrors())
+		require.Equal(t, "Invalid YAML configuration", diags[0].Summary)
+		require.Contains(t, diags[0].Detail, "Expanding aliases produces more than")
+	})
+}
+
+func TestParseBytes__YAMLRecursiveMerge(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		src    string
+		detail string
+	}{
+		{src: "a: &a {<<: *a}", detail: "The merge key refers to a mapping that contains it."},
+		{src: "a: &a {<<: [&b {<<: *a}]}", detail: "The merge key refers to a mapping that contains it."},
+		// 値の中で自身を取り込む場合は、エイリアスと同じく展開の上限で止まる
+		{src: "a: &a {b: {<<: *a}}", detail: "Expanding aliases produces more than 100000 values."},
+	}
+	for _, c := range cases {
+		_, _, diags := hclutil.ParseBytes("config.hcl.yaml", []byte(c.src))
+		require.True(t, diags.HasErrors(), c.src)
+		require.Equal(t, "Invalid YAML configuration", diags[0].Summary, c.src)
+		require.Equal(t, c.detail, diags[0].Detail, c.src)
+	}
+}